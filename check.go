@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/brookwarren/zerto-rpo/rpo"
+)
+
+// Nagios plugin exit statuses
+const (
+	checkOK       exitStatus = 0
+	checkWarning  exitStatus = 1
+	checkCritical exitStatus = 2
+)
+
+// exitStatus is returned up to main to exit with a specific code without
+// logging anything further, as the output has already been written
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// writeCheck writes a Nagios plugin status line comparing each VPG's RPO
+// against the warn and crit thresholds (in seconds, 0 to disable) and
+// returns the matching exit status. With maxBreaches above 0 only that many
+// of the offending VPGs are named, worst RPO first, followed by how many
+// more there are; every breach still counts towards the status.
+func writeCheck(w io.Writer, vpgs []VPG, warn, crit, maxBreaches int) exitStatus {
+	var warnings, criticals []breach
+	for _, b := range findBreaches(vpgs, warn, crit) {
+		if b.status == checkCritical {
+			criticals = append(criticals, b)
+		} else {
+			warnings = append(warnings, b)
+		}
+	}
+
+	perfData := fmt.Sprintf("rpo_avg=%d", rpo.ComputeStats(vpgs).Avg)
+	switch {
+	case len(criticals) > 0:
+		fmt.Fprintf(w, "CRITICAL - %s | %s\n", describeBreaches(criticals, maxBreaches), perfData)
+		return checkCritical
+	case len(warnings) > 0:
+		fmt.Fprintf(w, "WARNING - %s | %s\n", describeBreaches(warnings, maxBreaches), perfData)
+		return checkWarning
+	default:
+		fmt.Fprintf(w, "OK - all %d VPGs within RPO thresholds | %s\n", len(vpgs), perfData)
+		return checkOK
+	}
+}
+
+// describeBreaches lists breaches for the check output, in API order or,
+// when capped at limit, worst first with the remainder counted
+func describeBreaches(breaches []breach, limit int) string {
+	if limit > 0 {
+		sort.SliceStable(breaches, func(i, j int) bool {
+			if breaches[i].RPO != breaches[j].RPO {
+				return breaches[i].RPO > breaches[j].RPO
+			}
+			return breaches[i].VPG < breaches[j].VPG
+		})
+	}
+
+	shown := breaches
+	if limit > 0 && len(breaches) > limit {
+		shown = breaches[:limit]
+	}
+	texts := make([]string, len(shown))
+	for i, b := range shown {
+		texts[i] = fmt.Sprintf("VPG %s RPO %ds exceeds %ds", b.VPG, b.RPO, b.Threshold)
+	}
+
+	text := strings.Join(texts, ", ")
+	if more := len(breaches) - len(shown); more > 0 {
+		text += fmt.Sprintf(" (and %d more)", more)
+	}
+	return text
+}
+
+// breach is a VPG whose RPO exceeds the warn or crit threshold
+type breach struct {
+	VPG       string `json:"vpg"`
+	RPO       int    `json:"rpo"`
+	Threshold int    `json:"threshold"`
+
+	status exitStatus // checkWarning or checkCritical
+}
+
+// findBreaches returns the VPGs exceeding crit, or failing that warn, in
+// API order. A threshold of 0 is disabled.
+func findBreaches(vpgs []VPG, warn, crit int) []breach {
+	var breaches []breach
+	for _, vpg := range vpgs {
+		switch {
+		case crit > 0 && vpg.ActualRPO > crit:
+			breaches = append(breaches, breach{VPG: vpg.VpgName, RPO: vpg.ActualRPO, Threshold: crit, status: checkCritical})
+		case warn > 0 && vpg.ActualRPO > warn:
+			breaches = append(breaches, breach{VPG: vpg.VpgName, RPO: vpg.ActualRPO, Threshold: warn, status: checkWarning})
+		}
+	}
+	return breaches
+}
+
+// writeOver lists every VPG whose RPO exceeds threshold seconds, worst first
+func writeOver(w io.Writer, vpgs []VPG, threshold int) {
+	var over []VPG
+	for _, vpg := range vpgs {
+		if vpg.ActualRPO > threshold {
+			over = append(over, vpg)
+		}
+	}
+
+	if len(over) == 0 {
+		fmt.Fprintf(w, "All VPGs within %ds RPO\n", threshold)
+		return
+	}
+
+	sortByRPODesc(over)
+	for _, vpg := range over {
+		fmt.Fprintf(w, "%s: %ds\n", vpg.VpgName, vpg.ActualRPO)
+	}
+}
+
+// writeTop lists the n VPGs with the highest RPO, worst first, or all of
+// them when there are fewer than n
+func writeTop(w io.Writer, vpgs []VPG, n int) {
+	worst := sortedVPGs(vpgs, sortRPODesc)
+	if len(worst) > n {
+		worst = worst[:n]
+	}
+	for _, vpg := range worst {
+		fmt.Fprintf(w, "%s: %ds\n", vpg.VpgName, vpg.ActualRPO)
+	}
+}
+
+// writeSLA lists every VPG whose RPO exceeds its own configured target,
+// furthest over first, with both values and their ratio. VPGs without a
+// configured target are left out.
+func writeSLA(w io.Writer, vpgs []VPG) {
+	type breach struct {
+		vpg   VPG
+		ratio float64
+	}
+	var over []breach
+	for _, vpg := range vpgs {
+		if ratio, ok := vpg.TargetRatio(); ok && ratio > 1 {
+			over = append(over, breach{vpg: vpg, ratio: ratio})
+		}
+	}
+
+	if len(over) == 0 {
+		fmt.Fprintln(w, "All VPGs within their configured RPO")
+		return
+	}
+
+	sort.Slice(over, func(i, j int) bool {
+		if over[i].ratio != over[j].ratio {
+			return over[i].ratio > over[j].ratio
+		}
+		return over[i].vpg.VpgName < over[j].vpg.VpgName
+	})
+	for _, b := range over {
+		fmt.Fprintf(w, "%s: %ds exceeds target %ds (%.2fx)\n", b.vpg.VpgName, b.vpg.ActualRPO, b.vpg.ConfiguredRpoThresholdInSeconds, b.ratio)
+	}
+}
+
+// sortByRPODesc orders vpgs by descending RPO, breaking ties by name so the
+// order is stable across runs
+func sortByRPODesc(vpgs []VPG) {
+	sort.Slice(vpgs, func(i, j int) bool {
+		if vpgs[i].ActualRPO != vpgs[j].ActualRPO {
+			return vpgs[i].ActualRPO > vpgs[j].ActualRPO
+		}
+		return vpgs[i].VpgName < vpgs[j].VpgName
+	})
+}
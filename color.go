@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Supported values for the -color flag
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// ANSI escape sequences for the check statuses
+const (
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiRed    = "\x1b[31m"
+	ansiReset  = "\x1b[0m"
+)
+
+// useColor resolves the -color mode. auto colors only when the output goes
+// to stdout and stdout is a terminal, so pipes and files stay plain, and
+// never when NO_COLOR is set to anything (https://no-color.org). always
+// still colors, as an explicit flag outranks the environment.
+func useColor(mode, outFile string) (bool, error) {
+	switch mode {
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	case colorAuto:
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return outFile == "" && term.IsTerminal(int(os.Stdout.Fd())), nil
+	default:
+		return false, fmt.Errorf("unknown -color %q: must be %s, %s or %s", mode, colorAuto, colorAlways, colorNever)
+	}
+}
+
+// colorize wraps each line of text in the color for status: green for OK,
+// yellow for WARNING and red for CRITICAL
+func colorize(text string, status exitStatus) string {
+	color := ansiGreen
+	switch status {
+	case checkWarning:
+		color = ansiYellow
+	case checkCritical:
+		color = ansiRed
+	}
+
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		body, newline := strings.CutSuffix(line, "\n")
+		b.WriteString(color + body + ansiReset)
+		if newline {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestUseColorNoColor(t *testing.T) {
+	tests := []struct {
+		mode    string
+		noColor string
+		want    bool
+	}{
+		{colorAuto, "1", false},
+		{colorAuto, "anything", false},
+		{colorAlways, "1", true},
+		{colorNever, "", false},
+		{colorAlways, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode+" NO_COLOR="+tt.noColor, func(t *testing.T) {
+			t.Setenv("NO_COLOR", tt.noColor)
+			got, err := useColor(tt.mode, "")
+			if err != nil {
+				t.Fatalf("useColor: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("useColor(%q) = %v, want %v", tt.mode, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config struct holds the ZVM login credentials and optionally the server and
+// port to query, which the -server and -port flags override. ClientID and
+// ClientSecret select OAuth client-credentials login instead of
+// username/password. The same keys are used in JSON, YAML and TOML files.
+// Values are used exactly as decoded; note that YAML trims trailing spaces
+// from unquoted scalars and treats ": " as a key separator, so quote
+// passwords containing either.
+type Config struct {
+	Server       string `json:"server" yaml:"server" toml:"server"`
+	Port         int    `json:"port" yaml:"port" toml:"port"`
+	Username     string `json:"username" yaml:"username" toml:"username"`
+	Password     string `json:"password" yaml:"password" toml:"password"`
+	ClientID     string `json:"clientId" yaml:"clientId" toml:"clientId"`
+	ClientSecret string `json:"clientSecret" yaml:"clientSecret" toml:"clientSecret"`
+
+	// Token is a bearer token from -token, used instead of logging in. It
+	// is never read from a file.
+	Token string `json:"-" yaml:"-" toml:"-"`
+}
+
+const (
+	envUsername = "ZERTO_USERNAME"
+	envPassword = "ZERTO_PASSWORD"
+)
+
+// loadConfig reads credentials from configFile, or from the ZERTO_USERNAME
+// and ZERTO_PASSWORD environment variables when no file is given. A
+// passwordFile overrides the password from either source.
+func loadConfig(configFile, passwordFile string) (*Config, error) {
+	if configFile != "" {
+		return readConfig(configFile, passwordFile)
+	}
+
+	username, password := os.Getenv(envUsername), os.Getenv(envPassword)
+	if passwordFile != "" {
+		var err error
+		if password, err = readPasswordFile(passwordFile); err != nil {
+			return nil, err
+		}
+	}
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("config: no credentials, pass -config <file> or set both %s and %s", envUsername, envPassword)
+	}
+
+	return &Config{Username: username, Password: password}, nil
+}
+
+// readConfig parses a JSON, YAML or TOML config file chosen by its extension,
+// takes the password from passwordFile if given and validates the result
+func readConfig(configFile, passwordFile string) (*Config, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	config, err := decodeConfig(data, filepath.Ext(configFile))
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	if passwordFile != "" {
+		if config.Password, err = readPasswordFile(passwordFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// readPasswordFile returns the contents of a secret file holding just the
+// password, without the trailing newline most tools write. Any other
+// whitespace is kept as part of the password.
+func readPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("config: %w", err)
+	}
+
+	password := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	if password == "" {
+		return "", fmt.Errorf("config: password file %s is empty", path)
+	}
+	return password, nil
+}
+
+// decodeConfig parses data in the format implied by the file extension ext,
+// defaulting to JSON. Unknown keys are rejected in every format so that
+// typos are caught early.
+func decodeConfig(data []byte, ext string) (Config, error) {
+	var config Config
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, err
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), &config)
+		if err != nil {
+			return Config{}, err
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return Config{}, fmt.Errorf("unknown field %q", undecoded[0].String())
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&config); err != nil {
+			return Config{}, err
+		}
+	}
+
+	return config, nil
+}
+
+// validate checks that the config holds a usable set of credentials
+func (c *Config) validate() error {
+	if c.ClientID != "" || c.ClientSecret != "" {
+		if c.ClientID == "" {
+			return errors.New("config: clientId must not be empty when clientSecret is set")
+		}
+		if c.ClientSecret == "" {
+			return errors.New("config: clientSecret must not be empty when clientId is set")
+		}
+		return nil
+	}
+
+	if c.Username == "" {
+		return errors.New("config: username must not be empty")
+	}
+	if c.Password == "" {
+		return errors.New("config: password must not be empty")
+	}
+	return nil
+}
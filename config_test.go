@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestReadConfigKeepsPasswordBytes(t *testing.T) {
+	passwords := []string{
+		"pass:word",
+		"p@ss",
+		"pässwörd✓",
+		"trailing space ",
+		" leading space",
+	}
+
+	for _, password := range passwords {
+		t.Run(strconv.Quote(password), func(t *testing.T) {
+			data, err := json.Marshal(map[string]string{"username": "admin", "password": password})
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := readConfig(path, "")
+			if err != nil {
+				t.Fatalf("readConfig: %v", err)
+			}
+			if config.Password != password {
+				t.Errorf("password = %q, want %q", config.Password, password)
+			}
+		})
+	}
+}
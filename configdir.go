@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// target is a ZVM to connect to along with the config holding its
+// credentials. label names the environment in multi-server output; when it
+// is empty the host is used.
+type target struct {
+	label  string
+	addr   serverAddr
+	port   int // from -port or the config; addr.port takes precedence
+	config *Config
+}
+
+// configDirTargets reads every *.json config in dir as a separate
+// environment named after the file, with its own servers and credentials.
+// A file that fails to parse is logged and skipped so the other
+// environments are still reported.
+func configDirTargets(dir string, flagPort int) ([]target, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []target
+	for _, path := range paths {
+		config, err := readConfig(path, "")
+		var port int
+		if err == nil {
+			port, err = portValue(flagPort, config)
+		}
+		if err != nil {
+			slog.Warn("Skipping config in -config-dir", "file", path, "error", err)
+			continue
+		}
+
+		env := strings.TrimSuffix(filepath.Base(path), ".json")
+		hosts := splitServers(serverValue("", config))
+		for _, host := range hosts {
+			addr, err := parseServerAddr(host)
+			if err != nil {
+				slog.Warn("Skipping server in -config-dir", "file", path, "error", err)
+				continue
+			}
+			label := env
+			if len(hosts) > 1 {
+				label += " " + host
+			}
+			targets = append(targets, target{label: label, addr: addr, port: port, config: config})
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no usable *.json configs in %s", dir)
+	}
+	return targets, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// connection keeps a session to one ZVM across queries. It logs in lazily,
+// picks up a session from the token cache if one is configured, and logs in
+// again whenever Zerto rejects the current token.
+type connection struct {
+	client *http.Client
+	ep     endpoint
+	label  string // environment name from -config-dir, shown instead of the host
+	port   bool   // the server was given with a port, which name() then shows
+	config *Config
+	tokens *tokenCache
+	ema    *ema          // nil unless -alpha is set in watch mode
+	trend  *rpoTrend     // nil unless -trend is set in watch mode
+	limit  *rateLimiter  // per-VPG detail request rate; nil for no limit
+	ttl    time.Duration // -session-ttl; 0 reuses a session until rejected
+
+	mu     sync.Mutex
+	sess   session
+	timing apiTiming
+}
+
+// apiTiming records how long the phases of the last query took. login is
+// zero when an existing session was reused.
+type apiTiming struct {
+	login time.Duration
+	query time.Duration
+}
+
+// name identifies the connection in multi-server output: its -config-dir
+// environment if it has one, otherwise the host, with the port if the
+// server was given with one so that ZVMs sharing a host stay apart
+func (c *connection) name() string {
+	if c.label != "" {
+		return c.label
+	}
+	if c.port {
+		return net.JoinHostPort(c.ep.host, strconv.Itoa(c.ep.port))
+	}
+	return c.ep.host
+}
+
+// queryVPGs fetches every VPG on the ZVM, logging in first if necessary
+func (c *connection) queryVPGs(ctx context.Context, q vpgQuery) ([]VPG, error) {
+	var vpgs []VPG
+	err := c.eachVPGPage(ctx, q, func(batch []VPG) error {
+		vpgs = append(vpgs, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vpgs, nil
+}
+
+// eachVPGPage hands each page of VPGs on the ZVM to fn as it is fetched,
+// logging in first if necessary. A rejected session is only retried after
+// logging in again if fn has not yet been given any VPGs, so that none are
+// delivered twice.
+func (c *connection) eachVPGPage(ctx context.Context, q vpgQuery, fn func([]VPG) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timing = apiTiming{}
+	defer func() {
+		slog.Debug(fmt.Sprintf("login took %v, query took %v", c.timing.login.Round(time.Millisecond), c.timing.query.Round(time.Millisecond)), "server", c.ep.host)
+	}()
+
+	delivered := false
+	query := func() error {
+		return c.timedQuery(ctx, q, func(batch []VPG) error {
+			delivered = true
+			return fn(batch)
+		})
+	}
+	return c.withSession(ctx, "VPGs", query, func() bool { return !delivered })
+}
+
+// withSession runs query with the current or cached session, logging in
+// first if there is none. If the session turns out to have expired and
+// canRetry allows it, it logs in again and reruns query. Errors are wrapped
+// with what was being queried. The caller must hold c.mu.
+func (c *connection) withSession(ctx context.Context, what string, query func() error, canRetry func() bool) error {
+	if c.sess.expired(c.ttl, time.Now()) {
+		slog.Debug("Session reached -session-ttl, logging in again", "server", c.ep.host)
+		if c.tokens == nil {
+			logoutFromZerto(ctx, c.client, c.ep, c.sess)
+		}
+		c.tokens.invalidate(tokenKey(c.ep))
+		c.sess = session{}
+	}
+	if c.sess.token == "" {
+		c.sess, _ = c.tokens.load(tokenKey(c.ep))
+	}
+
+	if c.sess.token != "" {
+		err := query()
+		if !errors.Is(err, ErrSessionExpired) || !canRetry() {
+			return wrapQueryError(what, err)
+		}
+		slog.Debug("Session expired, logging in again", "server", c.ep.host)
+		c.tokens.invalidate(tokenKey(c.ep))
+		c.sess = session{}
+	}
+
+	start := time.Now()
+	sess, err := login(ctx, c.client, c.ep, c.config)
+	c.timing.login = time.Since(start)
+	if err != nil {
+		return loginError(fmt.Errorf("error logging in to Zerto API: %w", err))
+	}
+	c.sess = sess
+	c.tokens.store(tokenKey(c.ep), sess)
+
+	return wrapQueryError(what, query())
+}
+
+// timedQuery pages through the VPGs with the current session, adding the
+// time taken to c.timing
+func (c *connection) timedQuery(ctx context.Context, q vpgQuery, fn func([]VPG) error) error {
+	start := time.Now()
+	defer func() { c.timing.query += time.Since(start) }()
+
+	return eachVPGPage(ctx, c.client, c.ep, c.sess, q, fn)
+}
+
+// lastTiming returns the phase durations of the most recent query
+func (c *connection) lastTiming() apiTiming {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timing
+}
+
+// queryVPGStatuses fetches the journal history of vpgs with the session
+// established by the preceding queryVPGs
+func (c *connection) queryVPGStatuses(ctx context.Context, vpgs []VPG) []vpgJournal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return queryVPGStatuses(ctx, c.client, c.ep, c.sess, vpgs, c.limit)
+}
+
+// queryLastTests fetches the last recovery test of vpgs with the session
+// established by the preceding queryVPGs
+func (c *connection) queryLastTests(ctx context.Context, vpgs []VPG) []vpgTest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return queryLastTests(ctx, c.client, c.ep, c.sess, vpgs, c.limit)
+}
+
+// querySiteRPO fetches the peer sites and their pairing RPO, logging in
+// first if necessary
+func (c *connection) querySiteRPO(ctx context.Context) ([]peerSite, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sites []peerSite
+	query := func() (err error) {
+		sites, err = querySiteRPO(ctx, c.client, c.ep, c.sess)
+		return err
+	}
+	err := c.withSession(ctx, "peer sites", query, func() bool { return true })
+	return sites, err
+}
+
+// queryVRAs fetches the VRAs managed by the ZVM, logging in first if
+// necessary
+func (c *connection) queryVRAs(ctx context.Context) ([]vra, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var vras []vra
+	query := func() (err error) {
+		vras, err = queryVRAs(ctx, c.client, c.ep, c.sess)
+		return err
+	}
+	err := c.withSession(ctx, "VRAs", query, func() bool { return true })
+	return vras, err
+}
+
+// close logs out of the ZVM, unless the session is being kept in the token
+// cache for the next run. It deliberately uses a fresh context so that the
+// logout still goes through when shutting down after an interrupt.
+func (c *connection) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sess.token != "" && c.tokens == nil {
+		logoutFromZerto(context.Background(), c.client, c.ep, c.sess)
+	}
+	c.sess = session{}
+}
+
+// wrapQueryError adds context to a query failure. A session rejected even
+// after logging in, as with a bad -token, counts as a login error.
+func wrapQueryError(what string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrSessionExpired):
+		return loginError(fmt.Errorf("error querying %s: %w", what, err))
+	default:
+		return queryError(fmt.Errorf("error querying %s: %w", what, err))
+	}
+}
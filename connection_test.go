@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueryVPGsLogsInAgainAfterExpiry(t *testing.T) {
+	zvm := newMockZVM(t, `[{"VpgName":"web","ActualRPO":10}]`)
+	conn := zvm.connection(t)
+	// A session the ZVM no longer accepts, as after it expired mid-watch
+	conn.sess = session{token: "expired"}
+
+	vpgs, err := conn.queryVPGs(context.Background(), vpgQuery{})
+	if err != nil {
+		t.Fatalf("queryVPGs: %v", err)
+	}
+	if len(vpgs) != 1 || vpgs[0].VpgName != "web" {
+		t.Errorf("VPGs = %+v, want just web", vpgs)
+	}
+	if n := zvm.logins.Load(); n != 1 {
+		t.Errorf("%d logins, want 1", n)
+	}
+	if n := zvm.queries.Load(); n != 2 {
+		t.Errorf("%d VPG queries, want the rejected one and its retry", n)
+	}
+	if conn.sess.token != mockToken {
+		t.Errorf("session token = %q, want the new %q", conn.sess.token, mockToken)
+	}
+}
+
+func TestQueryVPGPageReportsExpiredSession(t *testing.T) {
+	zvm := newMockZVM(t, `[]`)
+
+	_, _, err := queryVPGPage(context.Background(), zvm.Client(), zvm.endpoint(t), session{token: "expired"}, 1, vpgQuery{})
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("error = %v, want ErrSessionExpired", err)
+	}
+}
+
+func TestSessionReusedWithinTTL(t *testing.T) {
+	zvm := newMockZVM(t, `[{"VpgName":"web","ActualRPO":10}]`)
+	conn := zvm.connection(t)
+	conn.ttl = time.Hour
+
+	for i := 0; i < 3; i++ {
+		if _, err := conn.queryVPGs(context.Background(), vpgQuery{}); err != nil {
+			t.Fatalf("query %d: %v", i+1, err)
+		}
+	}
+	if n := zvm.logins.Load(); n != 1 {
+		t.Errorf("%d logins for three queries within the TTL, want 1", n)
+	}
+
+	// Once the TTL has lapsed the next query logs in again
+	conn.sess.issued = time.Now().Add(-2 * time.Hour)
+	if _, err := conn.queryVPGs(context.Background(), vpgQuery{}); err != nil {
+		t.Fatalf("query after the TTL: %v", err)
+	}
+	if n := zvm.logins.Load(); n != 2 {
+		t.Errorf("%d logins after the TTL lapsed, want 2", n)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errDeadline is the cancellation cause once -deadline has passed
+var errDeadline = errors.New("overall -deadline exceeded")
+
+// withDeadline bounds ctx by the -deadline d, if set. Requests in flight
+// when it passes are cancelled along with any pending retry backoff.
+func withDeadline(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, d, errDeadline)
+}
+
+// explainDeadline rewords err when it is the result of -deadline passing,
+// which would otherwise show up as a generic timeout or context error.
+// The exit code of err is kept.
+func explainDeadline(ctx context.Context, d time.Duration, err error) error {
+	if err == nil || !errors.Is(context.Cause(ctx), errDeadline) {
+		return err
+	}
+	var status exitStatus
+	if errors.As(err, &status) {
+		return err
+	}
+	return fmt.Errorf("gave up when the overall -deadline of %v was reached: %w", d, err)
+}
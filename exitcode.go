@@ -0,0 +1,60 @@
+package main
+
+import "errors"
+
+// Exit codes for failures, telling apart what needs fixing. A threshold
+// breach exits with its Nagios status instead: 1 for WARNING, 2 for CRITICAL.
+// Query errors use 5 rather than 2 so that a wrapper can tell a CRITICAL
+// breach from a failed run.
+const (
+	exitLogin  exitStatus = 3 // authentication failed, check the credentials
+	exitConfig exitStatus = 4 // invalid flags or config
+	exitQuery  exitStatus = 5 // querying the API failed, often transiently
+)
+
+// exitCodesHelp is appended to the -help output
+const exitCodesHelp = `
+Exit codes:
+  0  success
+  1  threshold breach: WARNING with -warn/-crit
+  2  threshold breach: CRITICAL with -warn/-crit, -strict or -vra
+  3  login or authentication error
+  4  invalid flags or config
+  5  query error, often transient
+`
+
+// codedError attaches the exit code main should use to err
+type codedError struct {
+	code exitStatus
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+func configError(err error) error {
+	return &codedError{code: exitConfig, err: err}
+}
+
+func loginError(err error) error {
+	return &codedError{code: exitLogin, err: err}
+}
+
+func queryError(err error) error {
+	return &codedError{code: exitQuery, err: err}
+}
+
+// exitCode returns the code to exit with after err. Errors that have not
+// been given a code count as query errors, as they happen while running.
+func exitCode(err error) exitStatus {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return exitQuery
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brookwarren/zerto-rpo/rpo"
+)
+
+// exporter serves Prometheus metrics for a single ZVM, reusing the session
+// of its connection across scrapes. Every successful query also updates the
+// statistics served from /rpo.
+//
+// With a cacheTTL, scrapes within the TTL of the last query are answered from
+// its results, and concurrent scrapes wait for a single query rather than
+// each hitting the ZVM.
+type exporter struct {
+	conn     *connection
+	opts     reportOptions
+	cacheTTL time.Duration
+
+	scrapeMu     sync.Mutex // held while a scrape queries the ZVM
+	cachedVPGs   []VPG
+	cachedTiming apiTiming
+	cachedAt     time.Time
+
+	mu          sync.Mutex
+	stats       rpo.Stats
+	lastUpdated time.Time // zero until the first successful query
+	lastErr     error
+}
+
+// rpoSnapshot is the JSON document served from /rpo
+type rpoSnapshot struct {
+	AverageRPO  *int      `json:"averageRpo"`
+	VPGCount    int       `json:"vpgCount"`
+	MinRPO      float64   `json:"minRpo"`
+	MaxRPO      float64   `json:"maxRpo"`
+	MedianRPO   float64   `json:"medianRpo"`
+	P95RPO      float64   `json:"p95Rpo"`
+	StdDevRPO   float64   `json:"stdDevRpo"`
+	LastUpdated time.Time `json:"lastUpdated"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// serveMetrics runs an HTTP server on addr exposing /metrics and /rpo for
+// conn until ctx is cancelled. With an interval the ZVM is also polled in
+// the background so that /rpo stays fresh without any scrapes.
+func serveMetrics(ctx context.Context, addr string, conn *connection, opts reportOptions, interval, cacheTTL time.Duration) error {
+	e := &exporter{conn: conn, opts: opts, cacheTTL: cacheTTL}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	mux.HandleFunc("/rpo", e.serveRPO)
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	if interval > 0 {
+		go e.poll(ctx, interval)
+	}
+
+	slog.Info("Serving metrics", "server", conn.ep.host, "addr", addr, "path", "/metrics")
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vpgs, timing, age, err := e.scrape(r.Context())
+	if err != nil {
+		slog.Error("Scrape failed", "server", e.conn.ep.host, "error", err)
+		http.Error(w, fmt.Sprintf("error collecting VPGs from ZVM %s: %v", e.conn.ep.host, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := writePrometheus(w, vpgs, timing); err != nil {
+		slog.Error("Error writing metrics", "error", err)
+		return
+	}
+	if e.cacheTTL > 0 {
+		fmt.Fprintln(w, "# HELP zerto_scrape_cache_age_seconds Age of the cached query results served by this scrape in seconds.")
+		fmt.Fprintln(w, "# TYPE zerto_scrape_cache_age_seconds gauge")
+		fmt.Fprintf(w, "zerto_scrape_cache_age_seconds %g\n", age.Seconds())
+	}
+}
+
+// scrape returns the VPGs for a /metrics request and the timing of the query
+// that fetched them. Within the cacheTTL of the last query its results are
+// reused, and age is how long ago it ran.
+func (e *exporter) scrape(ctx context.Context) (vpgs []VPG, timing apiTiming, age time.Duration, err error) {
+	if e.cacheTTL <= 0 {
+		vpgs, err = e.collect(ctx)
+		return vpgs, e.conn.lastTiming(), 0, err
+	}
+
+	e.scrapeMu.Lock()
+	defer e.scrapeMu.Unlock()
+	if !e.cachedAt.IsZero() {
+		if age := time.Since(e.cachedAt); age < e.cacheTTL {
+			return e.cachedVPGs, e.cachedTiming, age, nil
+		}
+	}
+
+	if vpgs, err = e.refresh(ctx); err != nil {
+		return nil, apiTiming{}, 0, err
+	}
+	return vpgs, e.cachedTiming, 0, nil
+}
+
+// refresh queries the VPGs and, on success, replaces the scrape cache with
+// the results. The caller must hold scrapeMu.
+func (e *exporter) refresh(ctx context.Context) ([]VPG, error) {
+	vpgs, err := e.collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.cachedVPGs, e.cachedTiming, e.cachedAt = vpgs, e.conn.lastTiming(), time.Now()
+	return vpgs, nil
+}
+
+// collect queries the VPGs and records the outcome for /rpo
+func (e *exporter) collect(ctx context.Context) ([]VPG, error) {
+	vpgs, err := collectVPGs(ctx, e.conn, e.opts)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastErr = err
+	if err == nil {
+		e.stats = rpo.ComputeStats(vpgs)
+		e.lastUpdated = time.Now()
+	}
+	return vpgs, err
+}
+
+// poll refreshes the cached statistics, and with a cacheTTL the scrape
+// cache, every interval until ctx is cancelled
+func (e *exporter) poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.scrapeMu.Lock()
+		_, err := e.refresh(ctx)
+		e.scrapeMu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Poll failed", "server", e.conn.ep.host, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// roundStat rounds an interpolated or derived statistic to the two decimal
+// places -stats prints it with
+func roundStat(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// serveRPO writes the statistics of the last successful query as JSON,
+// without contacting the ZVM. It is unavailable until a query succeeds.
+func (e *exporter) serveRPO(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	snapshot := rpoSnapshot{
+		VPGCount:    e.stats.Count,
+		MinRPO:      e.stats.Min,
+		MaxRPO:      e.stats.Max,
+		MedianRPO:   roundStat(e.stats.Median),
+		P95RPO:      roundStat(e.stats.P95),
+		StdDevRPO:   roundStat(e.stats.StdDev),
+		LastUpdated: e.lastUpdated,
+	}
+	if e.stats.Count > 0 {
+		avg := e.stats.Avg
+		snapshot.AverageRPO = &avg
+	}
+	if e.lastErr != nil {
+		snapshot.LastError = e.lastErr.Error()
+	}
+	e.mu.Unlock()
+
+	if snapshot.LastUpdated.IsZero() {
+		http.Error(w, "no successful query of the ZVM yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		slog.Error("Error writing /rpo response", "error", err)
+	}
+}
@@ -1,3 +1,11 @@
 module github.com/brookwarren/zerto-rpo
 
 go 1.22.3
+
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/brookwarren/zerto-rpo/rpo"
+)
+
+// Supported values for the -groupby flag
+const (
+	groupBySite = "site"
+	groupByTag  = "tag"
+)
+
+// noSiteGroup is the site group of VPGs for which the API returned no
+// TargetSite
+const noSiteGroup = "(no site)"
+
+// groupKeys returns the groups a VPG belongs to for the given -groupby mode.
+// A VPG counts once towards each of its distinct tags, and an untagged VPG
+// is in no tag group. A VPG without a target site is in noSiteGroup.
+func groupKeys(groupBy string, vpg VPG) []string {
+	switch groupBy {
+	case groupBySite:
+		if vpg.TargetSite == "" {
+			return []string{noSiteGroup}
+		}
+		return []string{vpg.TargetSite}
+	case groupByTag:
+		tags := slices.Clone(vpg.Tags)
+		slices.Sort(tags)
+		return slices.Compact(tags)
+	default:
+		return nil
+	}
+}
+
+// writeGroupAverages writes the average RPO of each group, one
+// "<group>: <avg>" line per group sorted by name. Groups without VPGs
+// never appear.
+func writeGroupAverages(w io.Writer, vpgs []VPG, groupBy string) {
+	groups := make(map[string][]VPG)
+	for _, vpg := range vpgs {
+		for _, key := range groupKeys(groupBy, vpg) {
+			groups[key] = append(groups[key], vpg)
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %d\n", name, rpo.ComputeStats(groups[name]).Avg)
+	}
+}
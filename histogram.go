@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// defaultBuckets are the -buckets upper bounds in seconds
+const defaultBuckets = "10,60,300"
+
+// parseBuckets parses a comma-separated list of strictly increasing,
+// positive bucket boundaries in seconds
+func parseBuckets(value string) ([]int, error) {
+	var bounds []int
+	for _, field := range strings.Split(value, ",") {
+		bound, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket boundary %q", field)
+		}
+		if bound <= 0 || (len(bounds) > 0 && bound <= bounds[len(bounds)-1]) {
+			return nil, fmt.Errorf("bucket boundaries must be positive and increasing, got %s", value)
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+// writeHistogram writes the number of VPGs whose RPO falls in each bucket.
+// Buckets include their lower bound and exclude their upper one, and the
+// last is open-ended.
+func writeHistogram(w io.Writer, vpgs []VPG, bounds []int) {
+	counts := make([]int, len(bounds)+1)
+	for _, vpg := range vpgs {
+		i := 0
+		for i < len(bounds) && vpg.ActualRPO >= bounds[i] {
+			i++
+		}
+		counts[i]++
+	}
+
+	lower := 0
+	for i, bound := range bounds {
+		fmt.Fprintf(w, "%d-%ds: %d\n", lower, bound, counts[i])
+		lower = bound
+	}
+	fmt.Fprintf(w, "%ds+: %d\n", lower, counts[len(bounds)])
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// expectedVPGFields returns the JSON keys the VPG model decodes, with the
+// name taken from nameField, in declaration order
+func expectedVPGFields(nameField string) []string {
+	t := reflect.TypeOf(VPG{})
+	fields := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key == defaultNameField && nameField != "" {
+			key = nameField
+		}
+		fields = append(fields, key)
+	}
+	return fields
+}
+
+// introspectAll checks the VPG schema of each server for -introspect,
+// labelling the output by server when there are several
+func introspectAll(ctx context.Context, w io.Writer, conns []*connection, opts reportOptions) error {
+	var failed []string
+	for _, conn := range conns {
+		var buf bytes.Buffer
+		err := introspect(ctx, &buf, conn, opts)
+		if len(conns) == 1 {
+			w.Write(buf.Bytes())
+			return err
+		}
+		writePrefixed(w, conn.name()+": ", buf.Bytes())
+		if err != nil {
+			slog.Error("Introspection failed", "server", conn.name(), "error", err)
+			failed = append(failed, conn.name())
+		}
+	}
+
+	if len(failed) > 0 {
+		return queryError(fmt.Errorf("introspection failed for %d of %d servers: %s", len(failed), len(conns), strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// introspect fetches a single VPG as raw JSON and compares its keys with
+// the fields the VPG model expects, to catch a ZVM upgrade renaming fields
+// that would otherwise silently decode as zero. It lists the recognized and
+// unrecognized keys and warns about expected ones that are missing or null.
+// A missing name or ActualRPO is an error, as no report would be right.
+func introspect(ctx context.Context, w io.Writer, conn *connection, opts reportOptions) error {
+	raw, err := conn.queryRawVPG(ctx, opts.query())
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return queryError(fmt.Errorf("VPG is not a JSON object: %w", err))
+	}
+
+	expected := expectedVPGFields(opts.nameField)
+	var recognized, missing, null []string
+	for _, key := range expected {
+		value, ok := fields[key]
+		switch {
+		case !ok:
+			missing = append(missing, key)
+		case string(value) == "null":
+			null = append(null, key)
+		default:
+			recognized = append(recognized, key)
+		}
+	}
+
+	var unrecognized []string
+	for key := range fields {
+		if !slices.Contains(expected, key) {
+			unrecognized = append(unrecognized, key)
+		}
+	}
+	slices.Sort(unrecognized)
+
+	fmt.Fprintf(w, "recognized: %s\n", joinOrNone(recognized))
+	fmt.Fprintf(w, "unrecognized: %s\n", joinOrNone(unrecognized))
+	for _, key := range missing {
+		slog.Warn("Expected VPG field is missing", "server", conn.ep.host, "field", key)
+	}
+	for _, key := range null {
+		slog.Warn("Expected VPG field is null", "server", conn.ep.host, "field", key)
+	}
+
+	nameField := opts.nameField
+	if nameField == "" {
+		nameField = defaultNameField
+	}
+	for _, key := range []string{nameField, "ActualRPO"} {
+		if slices.Contains(missing, key) || slices.Contains(null, key) {
+			return queryError(fmt.Errorf("the VPG has no usable %s field; the ZVM API schema may have changed", key))
+		}
+	}
+	return nil
+}
+
+// queryRawVPG fetches the first VPG without decoding it
+func (c *connection) queryRawVPG(ctx context.Context, q vpgQuery) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	q.pageSize = 1
+	var page []json.RawMessage
+	query := func() error {
+		body, _, err := fetchVPGPage(ctx, c.client, c.ep, c.sess, 1, q)
+		if err != nil {
+			return err
+		}
+		if body, err = vpgListJSON(body); err != nil {
+			return fmt.Errorf("error unmarshalling JSON: %v", err)
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("error unmarshalling JSON: %v", err)
+		}
+		return nil
+	}
+	if err := c.withSession(ctx, "VPGs", query, func() bool { return true }); err != nil {
+		return nil, err
+	}
+
+	if len(page) == 0 {
+		return nil, queryError(errors.New("the Zerto API returned an empty VPG list, so there is nothing to introspect"))
+	}
+	return page[0], nil
+}
+
+// joinOrNone joins list for display, or returns "none" if it is empty
+func joinOrNone(list []string) string {
+	if len(list) == 0 {
+		return "none"
+	}
+	return strings.Join(list, ", ")
+}
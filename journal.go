@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// detailWorkers bounds the number of concurrent per-VPG detail requests so
+// that large sites don't flood the ZVM
+const detailWorkers = 4
+
+// vpgJournal is the journal history available for a VPG
+type vpgJournal struct {
+	VPG              VPG
+	OldestCheckpoint time.Time // zero if there are no checkpoints
+	Err              error
+}
+
+// checkpointStats is the response of the VPG checkpoints/stats endpoint
+type checkpointStats struct {
+	EarliestCheckpoint struct {
+		TimeStamp time.Time `json:"TimeStamp"`
+	} `json:"EarliestCheckpoint"`
+}
+
+// queryVPGStatuses fetches the oldest available checkpoint of every VPG.
+// Results are in the same order as vpgs, and a failure for one VPG is
+// recorded in its entry rather than aborting.
+func queryVPGStatuses(ctx context.Context, client *http.Client, ep endpoint, sess session, vpgs []VPG, limit *rateLimiter) []vpgJournal {
+	journals := make([]vpgJournal, len(vpgs))
+	forEachVPG(ctx, len(vpgs), limit, func(i int) {
+		oldest, err := queryOldestCheckpoint(ctx, client, ep, sess, vpgs[i].VpgIdentifier)
+		journals[i] = vpgJournal{VPG: vpgs[i], OldestCheckpoint: oldest, Err: err}
+	})
+	return journals
+}
+
+// forEachVPG calls fetch with each index below n using a pool of
+// detailWorkers, returning once every call has finished. Each call first
+// waits for limit, so the pool caps concurrency and limit caps the request
+// rate. If ctx ends while waiting, fetch still runs and fails on ctx itself.
+func forEachVPG(ctx context.Context, n int, limit *rateLimiter, fetch func(i int)) {
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(detailWorkers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				limit.wait(ctx)
+				fetch(i)
+			}
+		}()
+	}
+
+	for i := range n {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// queryOldestCheckpoint returns the time of the earliest checkpoint still in
+// the journal of the VPG with the given identifier
+func queryOldestCheckpoint(ctx context.Context, client *http.Client, ep endpoint, sess session, vpgID string) (time.Time, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", ep.url("v1/vpgs/"+url.PathEscape(vpgID)+"/checkpoints/stats"), nil)
+	sess.authorize(req, ep)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer closeBody(resp.Body)
+
+	if sessionRejected(resp.StatusCode) {
+		return time.Time{}, ErrSessionExpired
+	}
+	if err := expectJSON(resp); err != nil {
+		return time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("failed to query checkpoints, status code: %d", resp.StatusCode)
+	}
+
+	var stats checkpointStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return time.Time{}, fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+
+	return stats.EarliestCheckpoint.TimeStamp, nil
+}
+
+// writeJournal writes each VPG's oldest checkpoint and the resulting journal
+// retention relative to now
+func writeJournal(w io.Writer, journals []vpgJournal, now time.Time) {
+	for _, j := range journals {
+		switch {
+		case j.Err != nil:
+			fmt.Fprintf(w, "%s: error: %v\n", j.VPG.VpgName, j.Err)
+		case j.OldestCheckpoint.IsZero():
+			fmt.Fprintf(w, "%s: no checkpoints\n", j.VPG.VpgName)
+		default:
+			retention := now.Sub(j.OldestCheckpoint).Round(time.Second)
+			fmt.Fprintf(w, "%s: oldest checkpoint %s (journal %v)\n", j.VPG.VpgName, j.OldestCheckpoint.Format(time.RFC3339), retention)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// writeLogfmt writes the statistics of vpgs as a single logfmt line, e.g.
+// "avg=14 min=2 max=120 count=8 server=10.0.0.1"
+func writeLogfmt(w io.Writer, vpgs []VPG, opts reportOptions) error {
+	stats := computeMetricStats(vpgs, opts.metric)
+	_, err := fmt.Fprintf(w, "avg=%d min=%s max=%s count=%d server=%s\n",
+		averageRPO(vpgs, opts), plainFloat(stats.Min), plainFloat(stats.Max), stats.Count, logfmtValue(opts.server))
+	return err
+}
+
+// logfmtValue quotes s if it is empty or contains a space, quote, equals
+// sign or control character, so that it stays a single logfmt value
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\\") || strings.ContainsFunc(s, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+		return strconv.Quote(s)
+	}
+	return s
+}
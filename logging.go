@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// setupLogging sends diagnostics to stderr so stdout carries only results.
+// Only warnings and errors are logged unless verbose is set; quiet drops
+// warnings too, leaving only errors.
+func setupLogging(verbose, quiet bool) {
+	level := slog.LevelWarn
+	switch {
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelError
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
+
+// loggingTransport logs each HTTP round trip at debug level
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		slog.Debug("Request failed", "method", req.Method, "url", req.URL.Redacted(), "duration", time.Since(start), "error", err)
+		return nil, err
+	}
+
+	slog.Debug("Request completed", "method", req.Method, "url", req.URL.Redacted(), "status", resp.StatusCode, "duration", time.Since(start))
+	return resp, nil
+}
@@ -1,138 +1,1312 @@
 package main
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
+
+	"github.com/brookwarren/zerto-rpo/rpo"
 )
 
-// VPG struct represents the VPG details returned by the Zerto API
-type VPG struct {
-	ActualRPO int `json:"ActualRPO"`
+// VPG is the VPG model shared with the rpo package
+type VPG = rpo.VPG
+
+// rpoResult is the JSON document written by -format json
+type rpoResult struct {
+	AverageRPO *int `json:"averageRpo"`
+	VPGCount   int  `json:"vpgCount"`
+}
+
+// serverSummary is one server's entry in the array written by -format json
+// with several servers. Error is set instead of the result when the server
+// failed.
+type serverSummary struct {
+	Server string `json:"server"`
+	*rpoResult
+	Error string `json:"error,omitempty"`
 }
 
-// Config struct holds the ZVM login credentials
-type Config struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+// newServerSummary builds the summary of a server from the rpoResult
+// document its report wrote
+func newServerSummary(server string, output []byte) serverSummary {
+	var result rpoResult
+	if err := json.Unmarshal(output, &result); err != nil {
+		return serverSummary{Server: server, Error: fmt.Sprintf("unreadable result: %v", err)}
+	}
+	return serverSummary{Server: server, rpoResult: &result}
 }
 
 const (
 	defaultServerIP = "localhost"
-	zertoAPIPort    = 9669
 	apiTimeout      = 10 * time.Second
 )
 
+// Supported values for the -format flag
+const (
+	formatText   = "text"
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatProm   = "prometheus"
+	formatNDJSON = "ndjson"
+	formatTable  = "table"
+	formatLogfmt = "logfmt"
+)
+
 func main() {
-	serverIP := flag.String("server", defaultServerIP, "ZVM server IP")
-	configFile := flag.String("config", "", "Path to the config file")
-	flag.Parse()
+	if err := run(); err != nil {
+		var status exitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		slog.Error(err.Error())
+		os.Exit(int(exitCode(err)))
+	}
+}
+
+// run performs a login, query and report cycle per server, once or on an
+// interval. It returns rather than exiting on failure so that deferred
+// cleanup such as logout happens.
+func run() error {
+	serverIP := flag.String("server", defaultServerIP, "ZVM server IP or host, optionally with :port to override -port, or a comma-separated list of them")
+	serverFile := flag.String("server-file", "", "Read the servers to query from this file, one IP or ip:port per line")
+	configFile := flag.String("config", "", "Path to the config file (defaults to ZERTO_USERNAME/ZERTO_PASSWORD env vars)")
+	format := flag.String("format", formatText, "Output format: text, table, csv, json, ndjson, logfmt or prometheus")
+	showStats := flag.Bool("stats", false, "Print average, minimum, maximum, median and p95 RPO")
+	cacheTTL := flag.Duration("cache-ttl", 0, "With -serve, answer scrapes within this long of the last query (e.g. 15s) from its results instead of querying the ZVM again")
+	deadline := flag.Duration("deadline", 0, "Overall time limit for the whole run, including logins, retries and backoff (e.g. 50s); unlike -timeout it is not per request")
+	timeout := flag.Duration("timeout", apiTimeout, "HTTP request timeout (e.g. 30s, 1m)")
+	maxIdleConns := flag.Int("max-idle-conns", defaultMaxIdleConns, "Maximum number of idle connections kept open for reuse between requests")
+	idleConnTimeout := flag.Duration("idle-timeout", defaultIdleConnTimeout, "How long an idle connection is kept open for reuse (0 keeps it indefinitely)")
+	noKeepAlive := flag.Bool("no-keepalive", false, "Open a new connection for every request instead of reusing them")
+	retries := flag.Int("retries", 3, "Number of times to retry network errors and 5xx responses")
+	serve := flag.String("serve", "", "Listen address (e.g. :9100) to serve Prometheus metrics on /metrics and the last statistics as JSON on /rpo instead of running once; with -interval the ZVM is also polled in the background")
+	caCert := flag.String("cacert", "", "Path to a PEM CA bundle used to verify the ZVM certificate")
+	warn := flag.Int("warn", 0, "Nagios check mode: warn if any VPG RPO exceeds this many seconds")
+	statsdAddr := flag.String("statsd", "", "Also send the average RPO as a gauge to this StatsD host:port over UDP")
+	statsdPrefix := flag.String("statsd-prefix", defaultStatsDPrefix, "Prefix of the -statsd metric names, e.g. <prefix>.avg")
+	statsdVPGs := flag.Bool("statsd-vpgs", false, "With -statsd, also send each VPG's RPO as <prefix>.vpg.<name>")
+	webhookURL := flag.String("webhook", "", "With -warn/-crit, POST the breached VPGs to this URL (e.g. a Slack incoming webhook) whenever a threshold is exceeded")
+	webhookFormat := flag.String("webhook-format", webhookSlack, "Payload of -webhook: slack for a Slack message, or raw for the server, status and breaches as JSON")
+	maxBreaches := flag.Int("max-breaches", 0, "With -warn/-crit, name at most this many breaching VPGs, worst first, followed by \"(and N more)\" (0 names them all)")
+	crit := flag.Int("crit", 0, "Nagios check mode: critical if any VPG RPO exceeds this many seconds")
+	filter := flag.String("filter", "", "Only include VPGs whose name matches this regular expression")
+	apiVersion := flag.String("apiversion", apiV1, "Zerto API flavour: v1 (Windows ZVM) or v2 (ZVM appliance with keycloak)")
+	showStatus := flag.Bool("status", false, "Print a summary of VPG health by status")
+	sessionTTL := flag.Duration("session-ttl", defaultSessionTTL, "How long to reuse a session token before logging in again, across -interval polls and in -tokencache (0 reuses it until the ZVM rejects it)")
+	tokenCacheFile := flag.String("tokencache", "", "File in which to cache session tokens between runs")
+	verbose := flag.Bool("verbose", false, "Log debug details such as request URLs, status codes and timings to stderr")
+	token := flag.String("token", "", "Use this pre-acquired bearer token instead of logging in")
+	configDir := flag.String("config-dir", "", "Report every environment configured by a *.json file in this directory, each with its own server and credentials")
+	passwordFile := flag.String("password-file", "", "Read the password from this file, overriding the config and environment")
+	userAgent := flag.String("useragent", defaultUserAgent, "User-Agent header sent with every API request")
+	authHeader := flag.String("authheader", defaultSessionHeader, "Header carrying the session token for the v1 API")
+	showVMs := flag.Bool("vms", false, "Print the total number of protected VMs and the average RPO per VM")
+	quiet := flag.Bool("quiet", false, "Log only fatal errors to stderr, suppressing warnings")
+	colorMode := flag.String("color", colorAuto, "Color -warn/-crit results green, yellow or red: auto (only on a terminal without NO_COLOR set), always or never")
+	metric := flag.String("metric", metricActualRPO, "VPG field to average in the text output and -stats: "+metricNames())
+	precision := flag.Int("precision", 0, "Decimal places of the average RPO; 0 truncates to whole seconds, more rounds the exact average")
+	outputTemplate := flag.String("template", "", "Go text/template for the output, e.g. '{{.Avg}}s across {{.Count}} VPGs'; fields are Count, Avg, Mean, Min, Max, Median, P95, StdDev and VPGs")
+	source := flag.String("source", sourceVPGs, "Where the average RPO comes from: vpgs to average the VPGs, or site for the RPO the ZVM reports per peer site (one request, text output only)")
+	sortBy := flag.String("sort", "", "Order of the VPGs in csv, table and ndjson output: name, rpo or -rpo (default API order)")
+	basePath := flag.String("basepath", "", "Path prefix for every API request, for a ZVM behind a path-rewriting proxy (e.g. /zerto)")
+	nameField := flag.String("name-field", defaultNameField, "JSON field holding the VPG name, for ZVMs with a non-standard response")
+	vpgName := flag.String("vpg", "", "Report only the VPG with exactly this name")
+	pageSize := flag.Int("pagesize", 0, "Number of VPGs to request per page (0 lets the server decide)")
+	interval := flag.Duration("interval", 0, "Poll every interval (e.g. 1m) until interrupted instead of running once")
+	trendSize := flag.Int("trend", 0, "With -interval, print the trend and change since the previous poll, judged by the slope over this many polls (at least 2)")
+	alpha := flag.Float64("alpha", 0, "With -interval, also print an exponential moving average of the RPO with this smoothing factor (0 < alpha <= 1)")
+	groupBy := flag.String("groupby", "", "Print the average RPO per group instead of overall: site, or tag to count each VPG towards every one of its tags")
+	weighted := flag.Bool("weighted", false, "Weight the average RPO by VPG priority (High=4, Medium=2, Low=1)")
+	introspect := flag.Bool("introspect", false, "Fetch one VPG as raw JSON and list which of its fields are recognized, warning about expected fields that are missing or null")
+	vraCheck := flag.Bool("vra", false, "Check the health of the VRAs instead of reporting RPO, listing any that aren't installed and running with the number of VPGs they carry; exits CRITICAL if there are any")
+	check := flag.Bool("check", false, "Only verify the config and that login succeeds, then log out without querying VPGs")
+	proxy := flag.String("proxy", "", "Proxy URL (http://, https:// or socks5://); defaults to the HTTPS_PROXY environment variable")
+	includePaused := flag.Bool("include-paused", false, "Include VPGs whose replication is paused, and so whose RPO is frozen, instead of listing them in a warning and leaving them out")
+	sample := flag.Int("sample", 0, "Report an estimate of the plain text average from this many randomly chosen VPGs instead of all of them (0 uses every VPG)")
+	seed := flag.Uint64("seed", 0, "Seed for -sample, to pick the same VPGs again (default random)")
+	strict := flag.Bool("strict", false, "Treat a zero or missing ActualRPO (usually a VPG in initial sync) as an error: leave it out of the average, log the affected VPGs and exit 2")
+	failOnEmpty := flag.Bool("fail-on-empty", false, "Exit non-zero when no VPGs are found or none match -filter")
+	unit := flag.String("unit", "", "Print the average RPO in this unit with a suffix: s, m or h (default bare seconds)")
+	human := flag.Bool("human", false, "Print the average RPO, and per-VPG values in -format table, as a readable duration like 3h 12m 5s")
+	clientCert := flag.String("clientcert", "", "Path to a PEM client certificate for mutual TLS (requires -clientkey)")
+	clientKey := flag.String("clientkey", "", "Path to the PEM private key for -clientcert")
+	showCount := flag.Bool("count", false, "Append the number of VPGs averaged to the output, e.g. \"14 (8 VPGs)\"")
+	port := flag.Int("port", zertoAPIPort, "ZVM API port (defaults to 9669 for -apiversion v1 and 443 for v2)")
+	sla := flag.Bool("sla", false, "List the VPGs whose RPO exceeds their own configured RPO target, furthest over first")
+	over := flag.Int("over", -1, "List the VPGs whose RPO exceeds this many seconds, worst first")
+	top := flag.Int("top", 0, "List the `N` VPGs with the highest RPO, worst first")
+	histogram := flag.Bool("histogram", false, "Print the number of VPGs in each RPO bucket")
+	buckets := flag.String("buckets", defaultBuckets, "Comma-separated -histogram bucket boundaries in seconds")
+	journal := flag.Bool("journal", false, "Print each VPG's oldest checkpoint and journal retention (one extra API call per VPG)")
+	testAge := flag.Duration("test-age", 0, "List VPGs whose last recovery test is older than this (e.g. 720h) or that were never tested (one extra API call per VPG)")
+	detailRate := flag.Float64("rate", defaultDetailRate, "Maximum per-VPG detail requests per second to each ZVM for -journal and -test-age (0 for no limit)")
+	failFast := flag.Bool("fail-fast", false, "With several servers, stop at the first server that fails and report nothing")
+	bestEffort := flag.Bool("best-effort", false, "With several servers, exit 0 as long as at least one server was reported, logging the failures")
+	concurrency := flag.Int("concurrency", 4, "Maximum number of servers to query at once")
+	insecure := flag.Bool("insecure", true, "Skip TLS certificate verification when no -cacert is given; set to false to verify against the system roots")
+	tlsMin := flag.String("tls-min", "1.2", "Minimum TLS version to negotiate with the ZVM: 1.2 or 1.3")
+	outFile := flag.String("out", "", "Write the report to this file, replaced atomically, instead of stdout")
+	showVersion := flag.Bool("version", false, "Print version and build information, then exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesHelp)
+	}
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		// The flag package has already reported the error
+		return exitConfig
+	}
+
+	if *showVersion {
+		fmt.Printf("zerto-rpo %s (commit %s, built %s)\n", version, commit, buildDate)
+		return nil
+	}
 
-	if *configFile == "" {
-		log.Fatal("Config file path is required")
+	if *verbose && *quiet {
+		return configError(errors.New("-verbose and -quiet are mutually exclusive"))
 	}
+	setupLogging(*verbose, *quiet)
 
-	config, err := readConfig(*configFile)
+	switch *source {
+	case sourceVPGs:
+	case sourceSite:
+		if *format != formatText || *warn > 0 || *crit > 0 {
+			return configError(errors.New("-source site only supports the plain text average, without -format or -warn/-crit"))
+		}
+	default:
+		return configError(fmt.Errorf("unknown -source %q: must be %s or %s", *source, sourceVPGs, sourceSite))
+	}
+
+	if err := validSortOrder(*sortBy); err != nil {
+		return configError(err)
+	}
+	if *nameField == "" {
+		return configError(errors.New("-name-field must not be empty"))
+	}
+	if *authHeader == "" {
+		return configError(errors.New("-authheader must not be empty"))
+	}
+	if *apiVersion != apiV1 && *apiVersion != apiV2 {
+		return configError(fmt.Errorf("unknown -apiversion %q: must be %s or %s", *apiVersion, apiV1, apiV2))
+	}
+
+	if *retries < 0 {
+		return configError(fmt.Errorf("invalid -retries %d: must not be negative", *retries))
+	}
+
+	if *maxIdleConns < 0 {
+		return configError(fmt.Errorf("invalid -max-idle-conns %d: must not be negative", *maxIdleConns))
+	}
+	if *idleConnTimeout < 0 {
+		return configError(fmt.Errorf("invalid -idle-timeout %v: must not be negative", *idleConnTimeout))
+	}
+
+	if *detailRate < 0 {
+		return configError(fmt.Errorf("invalid -rate %g: must not be negative", *detailRate))
+	}
+
+	minTLS, err := parseTLSVersion(*tlsMin)
 	if err != nil {
-		log.Fatalf("Error reading config file: %v", err)
+		return configError(err)
+	}
+
+	if *sessionTTL < 0 {
+		return configError(fmt.Errorf("invalid -session-ttl %v: must not be negative", *sessionTTL))
+	}
+
+	if *cacheTTL < 0 {
+		return configError(fmt.Errorf("invalid -cache-ttl %v: must not be negative", *cacheTTL))
+	}
+	if *deadline < 0 {
+		return configError(fmt.Errorf("invalid -deadline %v: must not be negative", *deadline))
+	}
+
+	if *timeout <= 0 {
+		return configError(fmt.Errorf("invalid -timeout %v: must be greater than zero", *timeout))
+	}
+
+	switch *format {
+	case formatText, formatCSV, formatJSON, formatProm, formatNDJSON, formatTable, formatLogfmt:
+	default:
+		return configError(fmt.Errorf("unknown output format %q", *format))
+	}
+
+	if *interval < 0 {
+		return configError(fmt.Errorf("invalid -interval %v: must not be negative", *interval))
+	}
+	if isFlagSet("trend") {
+		if *interval == 0 {
+			return configError(errors.New("-trend requires -interval"))
+		}
+		if *trendSize < 2 {
+			return configError(fmt.Errorf("invalid -trend %d: must be at least 2", *trendSize))
+		}
+	}
+	if isFlagSet("alpha") {
+		if *interval == 0 {
+			return configError(errors.New("-alpha requires -interval"))
+		}
+		if *alpha <= 0 || *alpha > 1 {
+			return configError(fmt.Errorf("invalid -alpha %g: must be greater than 0 and at most 1", *alpha))
+		}
+	}
+
+	if _, ok := unitSeconds[*unit]; *unit != "" && !ok {
+		return configError(fmt.Errorf("unknown -unit %q: must be s, m or h", *unit))
+	}
+	if *human {
+		if *unit != "" || *precision > 0 {
+			return configError(errors.New("-human cannot be combined with -unit or -precision"))
+		}
+		*unit = unitHuman
+	}
+
+	var histogramBuckets []int
+	if *histogram {
+		bounds, err := parseBuckets(*buckets)
+		if err != nil {
+			return configError(fmt.Errorf("invalid -buckets: %w", err))
+		}
+		histogramBuckets = bounds
+	}
+
+	switch *groupBy {
+	case "", groupBySite, groupByTag:
+	default:
+		return configError(fmt.Errorf("unknown -groupby %q", *groupBy))
+	}
+
+	if *failFast && *bestEffort {
+		return configError(errors.New("-fail-fast and -best-effort are mutually exclusive"))
+	}
+	if *concurrency < 1 {
+		return configError(fmt.Errorf("invalid -concurrency %d: must be at least 1", *concurrency))
+	}
+
+	if *testAge < 0 {
+		return configError(fmt.Errorf("invalid -test-age %v: must not be negative", *testAge))
+	}
+	color, err := useColor(*colorMode, *outFile)
+	if err != nil {
+		return configError(err)
+	}
+	if _, ok := metricFields[*metric]; !ok {
+		return configError(fmt.Errorf("unknown -metric %q: must be one of %s", *metric, metricNames()))
+	}
+	if *metric != metricActualRPO && *human {
+		return configError(errors.New("-metric other than ActualRPO cannot be combined with -human"))
+	}
+	if *metric != metricActualRPO && (*format != formatText || *unit != "") {
+		return configError(errors.New("-metric other than ActualRPO applies only to the text output without -unit"))
+	}
+	if *precision < 0 {
+		return configError(fmt.Errorf("invalid -precision %d: must not be negative", *precision))
+	}
+	if *pageSize < 0 {
+		return configError(fmt.Errorf("invalid -pagesize %d: must not be negative", *pageSize))
+	}
+
+	if *warn < 0 || *crit < 0 {
+		return configError(errors.New("-warn and -crit must not be negative"))
+	}
+	if *top < 0 {
+		return configError(fmt.Errorf("invalid -top %d: must not be negative", *top))
+	}
+	if *sample < 0 {
+		return configError(fmt.Errorf("invalid -sample %d: must not be negative", *sample))
+	}
+	var sampleFrom uint64
+	if *sample > 0 {
+		// Only the plain average line says that it is an estimate, and an
+		// alert or SLA must not rest on a sample
+		if *format != formatText {
+			return configError(errors.New("-sample only estimates the plain text average and cannot be combined with -format"))
+		}
+		for _, name := range []string{"warn", "crit", "webhook", "over", "top", "sla", "strict", "serve", "stats", "status", "histogram", "groupby", "vms", "vpg", "template", "statsd"} {
+			if isFlagSet(name) {
+				return configError(fmt.Errorf("-sample only estimates the plain text average and cannot be combined with -%s", name))
+			}
+		}
+		sampleFrom = sampleSeed(*seed, isFlagSet("seed"))
+	}
+	if *maxBreaches < 0 {
+		return configError(fmt.Errorf("invalid -max-breaches %d: must not be negative", *maxBreaches))
+	}
+
+	var notifier *webhook
+	if *webhookURL != "" {
+		if *webhookFormat != webhookSlack && *webhookFormat != webhookRaw {
+			return configError(fmt.Errorf("unknown -webhook-format %q: must be %s or %s", *webhookFormat, webhookSlack, webhookRaw))
+		}
+		if *warn == 0 && *crit == 0 {
+			return configError(errors.New("-webhook requires -warn or -crit"))
+		}
+		if u, err := url.Parse(*webhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return configError(fmt.Errorf("invalid -webhook %q: must be an http or https URL", *webhookURL))
+		}
+		notifier = &webhook{url: *webhookURL, format: *webhookFormat, client: &http.Client{Timeout: *timeout}}
+	}
+
+	var statsd *statsdSink
+	if *statsdAddr != "" {
+		if _, _, err := net.SplitHostPort(*statsdAddr); err != nil {
+			return configError(fmt.Errorf("invalid -statsd %q: must be host:port", *statsdAddr))
+		}
+		if strings.Trim(*statsdPrefix, ".") == "" {
+			return configError(errors.New("-statsd-prefix must not be empty"))
+		}
+		statsd = &statsdSink{addr: *statsdAddr, prefix: strings.Trim(*statsdPrefix, "."), perVPG: *statsdVPGs}
+	}
+
+	var nameFilter *regexp.Regexp
+	if *filter != "" {
+		re, err := regexp.Compile(*filter)
+		if err != nil {
+			return configError(fmt.Errorf("invalid -filter: %w", err))
+		}
+		nameFilter = re
+	}
+
+	var tmpl *template.Template
+	if *outputTemplate != "" {
+		if *format != formatText {
+			return configError(fmt.Errorf("-template cannot be combined with -format %s", *format))
+		}
+		if tmpl, err = parseOutputTemplate(*outputTemplate); err != nil {
+			return configError(fmt.Errorf("invalid -template: %w", err))
+		}
+	}
+
+	var config *Config
+	switch {
+	case *configDir != "":
+		if *configFile != "" || *passwordFile != "" || *token != "" || *serverFile != "" || isFlagSet("server") {
+			return configError(errors.New("-config-dir takes the servers and credentials from its files and cannot be combined with -config, -password-file, -token, -server or -server-file"))
+		}
+	case *token != "":
+		if *configFile != "" || *passwordFile != "" {
+			return configError(errors.New("-token cannot be combined with -config or -password-file credentials"))
+		}
+		config = &Config{Token: *token}
+	default:
+		loaded, err := loadConfig(*configFile, *passwordFile)
+		if err != nil {
+			return configError(err)
+		}
+		config = loaded
+	}
+
+	proxyURL, err := proxyFunc(*proxy)
+	if err != nil {
+		return configError(err)
+	}
+
+	tlsConfig, err := buildTLSConfig(*caCert, *clientCert, *clientKey, *insecure, minTLS)
+	if err != nil {
+		return configError(err)
 	}
 
 	jar, _ := cookiejar.New(nil)
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		Proxy:           proxyURL,
+	}
+	poolOptions{
+		maxIdleConns:      *maxIdleConns,
+		idleConnTimeout:   *idleConnTimeout,
+		disableKeepAlives: *noKeepAlive,
+	}.apply(transport)
+
 	client := &http.Client{
 		Jar:     jar,
-		Timeout: apiTimeout,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		Timeout: *timeout,
+		Transport: &userAgentTransport{
+			next: &retryTransport{
+				next:    &loggingTransport{next: transport},
+				retries: *retries,
+			},
+			userAgent: *userAgent,
 		},
 	}
 
-	sessionToken, err := loginToZerto(client, *serverIP, config.Username, config.Password)
+	opts := reportOptions{
+		format:      *format,
+		showStats:   *showStats,
+		showStatus:  *showStatus,
+		warn:        *warn,
+		crit:        *crit,
+		maxBreaches: *maxBreaches,
+		filter:      nameFilter,
+		pageSize:    *pageSize,
+		vpgName:     *vpgName,
+		nameField:   *nameField,
+		sortBy:      *sortBy,
+		source:      *source,
+		template:    tmpl,
+		precision:   *precision,
+		metric:      *metric,
+		color:       color,
+		groupBy:     *groupBy,
+		weighted:    *weighted,
+		failOnEmpty: *failOnEmpty,
+		strict:      *strict,
+		sample:      *sample,
+		seed:        sampleFrom,
+		withPaused:  *includePaused,
+		webhook:     notifier,
+		statsd:      statsd,
+		unit:        *unit,
+		showCount:   *showCount,
+		over:        *over,
+		top:         *top,
+		sla:         *sla,
+		buckets:     histogramBuckets,
+		journal:     *journal,
+		testAge:     *testAge,
+		concurrency: *concurrency,
+		failFast:    *failFast,
+		bestEffort:  *bestEffort,
+		showVMs:     *showVMs,
+	}
+	var tokens *tokenCache
+	if *tokenCacheFile != "" && *token == "" {
+		tokens = &tokenCache{path: *tokenCacheFile, ttl: *sessionTTL}
+	}
+
+	var targets []target
+	if *configDir != "" {
+		if targets, err = configDirTargets(*configDir, *port); err != nil {
+			return configError(fmt.Errorf("invalid -config-dir: %w", err))
+		}
+	} else {
+		apiPort, err := portValue(*port, config)
+		if err != nil {
+			return configError(err)
+		}
+
+		var servers []serverAddr
+		if *serverFile != "" {
+			if isFlagSet("server") {
+				return configError(errors.New("-server and -server-file are mutually exclusive"))
+			}
+			if servers, err = readServerFile(*serverFile); err != nil {
+				return configError(fmt.Errorf("invalid -server-file: %w", err))
+			}
+		} else {
+			for _, host := range splitServers(serverValue(*serverIP, config)) {
+				server, err := parseServerAddr(host)
+				if err != nil {
+					return configError(fmt.Errorf("invalid -server %q: %w", host, err))
+				}
+				servers = append(servers, server)
+			}
+		}
+		for _, server := range servers {
+			targets = append(targets, target{addr: server, port: apiPort, config: config})
+		}
+	}
+
+	conns := make([]*connection, len(targets))
+	for i, t := range targets {
+		serverPort := t.port
+		if t.addr.port != 0 {
+			serverPort = t.addr.port
+		}
+		conns[i] = &connection{
+			client: client,
+			ep:     endpoint{host: t.addr.host, port: serverPort, apiVersion: *apiVersion, authHeader: *authHeader, basePath: strings.Trim(*basePath, "/")},
+			label:  t.label,
+			port:   t.addr.port != 0,
+			config: t.config,
+			tokens: tokens,
+			limit:  newRateLimiter(*detailRate, detailWorkers),
+			ttl:    *sessionTTL,
+		}
+		if *alpha > 0 {
+			conns[i].ema = &ema{alpha: *alpha}
+		}
+		if *trendSize > 0 {
+			conns[i].trend = newRPOTrend(*trendSize)
+		}
+		defer conns[i].close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *deadline > 0 && (*serve != "" || *interval > 0) {
+		return configError(errors.New("-deadline bounds a single run and cannot be combined with -serve or -interval"))
+	}
+	ctx, cancel := withDeadline(ctx, *deadline)
+	defer cancel()
+
+	if *check {
+		return explainDeadline(ctx, *deadline, checkAll(ctx, os.Stdout, conns))
+	}
+	if *introspect {
+		return explainDeadline(ctx, *deadline, introspectAll(ctx, os.Stdout, conns, opts))
+	}
+	if *vraCheck {
+		return explainDeadline(ctx, *deadline, vraAll(ctx, os.Stdout, conns))
+	}
+
+	if *cacheTTL > 0 && *serve == "" {
+		return configError(errors.New("-cache-ttl requires -serve"))
+	}
+	if *serve != "" {
+		if len(conns) != 1 {
+			return configError(errors.New("-serve supports a single -server only"))
+		}
+		return serveMetrics(ctx, *serve, conns[0], opts, *interval, *cacheTTL)
+	}
+	if opts.format == formatProm && opts.warn == 0 && opts.crit == 0 && (len(conns) > 1 || conns[0].label != "") {
+		// Prefixing each line with the server would break the exposition
+		// format, and repeating a metric family per server is invalid too
+		return configError(errors.New("-format prometheus supports a single server only; use -serve with one exporter per server"))
+	}
+
+	if *interval > 0 {
+		return watch(ctx, conns, opts, *interval, *outFile)
+	}
+
+	return explainDeadline(ctx, *deadline, report(ctx, conns, opts, "", *outFile))
+}
+
+// report runs reportAll, writing its output to stdout or, when out is set,
+// atomically replacing that file. A failed query leaves the file untouched.
+func report(ctx context.Context, conns []*connection, opts reportOptions, prefix, out string) error {
+	if out == "" {
+		return reportAll(ctx, os.Stdout, conns, opts, prefix)
+	}
+
+	var buf bytes.Buffer
+	err := reportAll(ctx, &buf, conns, opts, prefix)
+	var status exitStatus
+	if err != nil && !errors.As(err, &status) {
+		return err
+	}
+	if werr := writeFileAtomic(out, buf.Bytes(), 0o644); werr != nil {
+		return fmt.Errorf("error writing output: %w", werr)
+	}
+	return err
+}
+
+// watch reports on every server each interval until ctx is cancelled.
+// Text, table and check lines are prefixed by a timestamp; the machine
+// formats are left as they are so that each poll still parses. Sessions are
+// kept open between polls. With out set, each poll replaces the file rather
+// than appending to it.
+func watch(ctx context.Context, conns []*connection, opts reportOptions, interval time.Duration, out string) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	timestamped := opts.format == formatText || opts.format == formatTable || opts.warn > 0 || opts.crit > 0
+	for {
+		prefix := ""
+		if timestamped {
+			prefix = time.Now().Format(time.RFC3339) + " "
+		}
+		err := report(ctx, conns, opts, prefix, out)
+		var status exitStatus
+		if err != nil && !errors.As(err, &status) && ctx.Err() == nil {
+			slog.Error("Poll failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAll logs in to and straight back out of each server, reporting
+// whether authentication succeeded. A -token needs no login, so it is
+// checked with a single one-VPG query instead. Any failure makes it return
+// an error.
+func checkAll(ctx context.Context, w io.Writer, conns []*connection) error {
+	var failed []string
+	for _, conn := range conns {
+		sess, err := login(ctx, conn.client, conn.ep, conn.config)
+		if err == nil && conn.config.Token != "" {
+			err = verifyToken(ctx, conn, sess)
+		}
+		if err != nil {
+			if len(conns) == 1 {
+				return loginError(fmt.Errorf("check failed for %s: %w", conn.name(), err))
+			}
+			slog.Error("Check failed", "server", conn.name(), "error", err)
+			failed = append(failed, conn.name())
+			continue
+		}
+		logoutFromZerto(ctx, conn.client, conn.ep, sess)
+		fmt.Fprintf(w, "OK: authenticated to %s\n", conn.name())
+	}
+
+	if len(failed) > 0 {
+		return loginError(fmt.Errorf("check failed for %d of %d servers: %s", len(failed), len(conns), strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// verifyToken makes one authenticated request with the bearer session of a
+// -token, which login accepts without contacting the ZVM
+func verifyToken(ctx context.Context, conn *connection, sess session) error {
+	_, _, err := fetchVPGPage(ctx, conn.client, conn.ep, sess, 1, vpgQuery{pageSize: 1})
+	if errors.Is(err, ErrSessionExpired) {
+		return errors.New("token rejected by Zerto API")
+	}
 	if err != nil {
-		log.Fatalf("Error logging in to Zerto API: %v", err)
+		return describeNetError(conn.ep, err)
+	}
+	return nil
+}
+
+// reportAll writes the report for each server to w with every line prefixed
+// by prefix. With several servers, or with -config-dir environments, each
+// line is also prefixed with the server address or environment name, or
+// carries it in a column or field for csv and ndjson, and individual
+// failures are logged rather than stopping the run.
+func reportAll(ctx context.Context, w io.Writer, conns []*connection, opts reportOptions, prefix string) error {
+	single := len(conns) == 1 && conns[0].label == ""
+	if single && prefix == "" && opts.format == formatNDJSON {
+		// Stream straight through rather than holding every VPG in memory
+		return reportServer(ctx, w, conns[0], opts)
+	}
+	if single {
+		var buf bytes.Buffer
+		err := reportServer(ctx, &buf, conns[0], opts)
+		writePrefixed(w, prefix, buf.Bytes())
+		return err
+	}
+
+	opts.perServer = true
+	results := reportConcurrently(ctx, conns, opts)
+	if opts.failFast {
+		if i, ok := firstFailure(results); ok {
+			return fmt.Errorf("%s: %w", conns[i].name(), results[i].err)
+		}
+	}
+
+	// JSON output is collected into one array rather than prefixing each
+	// server's document, so that the whole output stays valid JSON
+	summaries := opts.format == formatJSON && opts.warn == 0 && opts.crit == 0
+	var summary []serverSummary
+	// csv and ndjson rows carry the server themselves, under one csv header
+	rows := (opts.format == formatCSV || opts.format == formatNDJSON) && opts.warn == 0 && opts.crit == 0
+	if rows && opts.format == formatCSV {
+		io.WriteString(w, prefix+strings.Join(csvHeader(true), ",")+"\n")
+	}
+
+	failed := 0
+	worst, failure := checkOK, checkOK
+	for i, result := range results {
+		host := conns[i].name()
+		if serverFailed(result.err) {
+			slog.Error("Server failed", "server", host, "error", result.err)
+			failed++
+			failure = max(failure, exitCode(result.err))
+			if summaries {
+				summary = append(summary, serverSummary{Server: host, Error: result.err.Error()})
+			}
+			continue
+		}
+		var status exitStatus
+		errors.As(result.err, &status)
+		worst = max(worst, status)
+		switch {
+		case summaries:
+			summary = append(summary, newServerSummary(host, result.output))
+		case rows || opts.format == formatLogfmt:
+			// The server is already a column, field or logfmt key
+			writePrefixed(w, prefix, result.output)
+		default:
+			writePrefixed(w, prefix+host+": ", result.output)
+		}
+	}
+
+	if summaries {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(summary); err != nil {
+			return fmt.Errorf("error writing JSON: %w", err)
+		}
+		writePrefixed(w, prefix, buf.Bytes())
+	}
+
+	// -best-effort only fails the run when no server could be reported
+	if failed > 0 && (!opts.bestEffort || failed == len(conns)) {
+		return &codedError{code: failure, err: fmt.Errorf("%d of %d servers failed", failed, len(conns))}
+	}
+	if worst != checkOK {
+		return worst
+	}
+
+	return nil
+}
+
+// serverFailed reports whether a server's report failed outright, as
+// opposed to succeeding or ending in a threshold status
+func serverFailed(err error) bool {
+	var status exitStatus
+	return err != nil && !errors.As(err, &status)
+}
+
+// firstFailure returns the index of the server whose failure stopped a
+// -fail-fast run. Servers cancelled because of it are skipped, unless every
+// failure is a cancellation, as after an interrupt.
+func firstFailure(results []serverResult) (int, bool) {
+	first := -1
+	for i, result := range results {
+		if !serverFailed(result.err) {
+			continue
+		}
+		if !errors.Is(result.err, context.Canceled) {
+			return i, true
+		}
+		if first < 0 {
+			first = i
+		}
+	}
+	return first, first >= 0
+}
+
+// serverResult is the buffered outcome of reporting on one server
+type serverResult struct {
+	index  int
+	output []byte
+	err    error
+}
+
+// reportConcurrently reports on each server using a pool of
+// opts.concurrency workers. The results are returned in the same order as
+// conns regardless of which servers finish first.
+func reportConcurrently(ctx context.Context, conns []*connection, opts reportOptions) []serverResult {
+	jobs := make(chan int)
+	results := make(chan serverResult)
+
+	// With -fail-fast the first failure cancels the servers still running
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for range min(opts.concurrency, len(conns)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				var buf bytes.Buffer
+				err := reportServer(ctx, &buf, conns[i], opts)
+				if opts.failFast && serverFailed(err) {
+					cancel()
+				}
+				results <- serverResult{index: i, output: buf.Bytes(), err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for i := range conns {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	ordered := make([]serverResult, len(conns))
+	for result := range results {
+		ordered[result.index] = result
+	}
+	return ordered
+}
+
+// reportOptions controls how each server is queried and its VPGs rendered
+type reportOptions struct {
+	format      string
+	showStats   bool
+	showStatus  bool
+	warn        int
+	crit        int
+	maxBreaches int
+	filter      *regexp.Regexp
+	pageSize    int
+	vpgName     string
+	nameField   string
+	sortBy      string
+	source      string
+	template    *template.Template
+	precision   int
+	metric      string
+	color       bool
+	groupBy     string
+	weighted    bool
+	failOnEmpty bool
+	strict      bool
+	sample      int
+	seed        uint64
+	withPaused  bool
+	webhook     *webhook
+	statsd      *statsdSink
+	unit        string
+	showCount   bool
+	over        int // -1 when not set
+	top         int
+	sla         bool
+	buckets     []int // -histogram bucket boundaries, nil when not set
+	journal     bool
+	testAge     time.Duration // 0 when not set
+	concurrency int
+	failFast    bool
+	bestEffort  bool
+	showVMs     bool
+	smoothed    *float64    // EMA of the average RPO, set per server in watch mode
+	trend       *trendPoint // RPO trend, set per server in watch mode
+	server      string      // name of the server being reported, set per server
+	population  int         // VPGs a -sample was drawn from, set per server
+	perServer   bool        // several servers are reported, set by reportAll
+}
+
+// query returns the VPG list request parameters for opts
+func (opts reportOptions) query() vpgQuery {
+	return vpgQuery{pageSize: opts.pageSize, name: opts.vpgName, nameField: opts.nameField}
+}
+
+// rowServer returns the server to put in every csv and ndjson row, which is
+// empty unless the report covers several servers
+func (opts reportOptions) rowServer() string {
+	if !opts.perServer {
+		return ""
+	}
+	return opts.server
+}
+
+// matches reports whether vpg passes -vpg and -filter. -vpg is checked
+// here too, in case the ZVM ignored the name in the request.
+func (opts reportOptions) matches(vpg VPG) bool {
+	if opts.vpgName != "" && vpg.VpgName != opts.vpgName {
+		return false
+	}
+	return opts.filter == nil || opts.filter.MatchString(vpg.VpgName)
+}
+
+// reportServer queries the VPGs of a single ZVM and writes the report to w
+func reportServer(ctx context.Context, w io.Writer, conn *connection, opts reportOptions) error {
+	if opts.source == sourceSite {
+		return reportSiteRPO(ctx, w, conn, opts)
+	}
+	if opts.format == formatNDJSON && opts.sample == 0 && opts.warn == 0 && opts.crit == 0 && !opts.journal && opts.testAge == 0 && opts.sortBy == "" && !opts.strict && opts.statsd == nil {
+		return streamNDJSON(ctx, w, conn, opts)
 	}
 
-	averageRPO, err := queryVPGs(client, *serverIP, sessionToken)
+	vpgs, err := collectVPGs(ctx, conn, opts)
 	if err != nil {
-		log.Fatalf("Error querying VPGs: %v", err)
+		return err
+	}
+	if opts.sample > 0 && len(vpgs) > opts.sample {
+		slog.Warn("Reporting an estimate from a sample of the VPGs", "server", conn.ep.host, "sample", opts.sample, "vpgs", len(vpgs))
+		opts.population = len(vpgs)
+		vpgs = sampleVPGs(vpgs, opts.sample, opts.seed)
+	}
+
+	if opts.journal {
+		writeJournal(w, conn.queryVPGStatuses(ctx, vpgs), time.Now())
+		return nil
+	}
+	if opts.testAge > 0 {
+		writeStaleTests(w, conn.queryLastTests(ctx, vpgs), opts.testAge, time.Now())
+		return nil
+	}
+
+	var unsynced []string
+	if opts.strict {
+		vpgs, unsynced = splitZeroRPO(vpgs)
+		if len(unsynced) > 0 {
+			slog.Error("VPGs report no RPO", "server", conn.ep.host, "count", len(unsynced), "vpgs", strings.Join(unsynced, ", "))
+		}
+	}
+
+	if conn.ema != nil {
+		smoothed := conn.ema.update(float64(averageRPO(vpgs, opts)))
+		opts.smoothed = &smoothed
+	}
+	if conn.trend != nil {
+		avg := float64(averageRPO(vpgs, opts))
+		if opts.precision > 0 {
+			avg = meanRPO(vpgs, opts)
+		}
+		if point, ok := conn.trend.update(avg); ok {
+			slog.Debug("RPO trend", "server", conn.ep.host, "delta", point.delta, "slope", point.slope)
+			opts.trend = &point
+		}
 	}
 
-	fmt.Println(averageRPO)
+	if opts.statsd != nil {
+		opts.statsd.send(vpgs, averageRPO(vpgs, opts))
+	}
+
+	opts.server = conn.name()
+	err = writeReport(w, vpgs, conn.lastTiming(), opts)
+	if status, ok := err.(exitStatus); ok && opts.webhook != nil {
+		opts.webhook.notify(ctx, conn.name(), status, findBreaches(vpgs, opts.warn, opts.crit))
+	}
+	if len(unsynced) > 0 {
+		// A threshold breach already reported is kept unless this is worse
+		var status exitStatus
+		if err == nil || errors.As(err, &status) && status < checkCritical {
+			return checkCritical
+		}
+	}
+	return err
+}
+
+// splitZeroRPO separates the VPGs reporting an ActualRPO of zero, which the
+// API also returns when the field is missing, from the rest for -strict. It
+// returns the remaining VPGs and the names of the zero ones.
+func splitZeroRPO(vpgs []VPG) ([]VPG, []string) {
+	var kept []VPG
+	var zero []string
+	for _, vpg := range vpgs {
+		if vpg.ActualRPO == 0 {
+			zero = append(zero, vpg.VpgName)
+			continue
+		}
+		kept = append(kept, vpg)
+	}
+	return kept, zero
 }
 
-func readConfig(configFile string) (*Config, error) {
-	data, err := os.ReadFile(configFile)
+// collectVPGs queries the VPGs of a ZVM and applies any -vpg and -filter,
+// leaving out paused VPGs unless -include-paused or -vpg is given.
+// With -fail-on-empty, ending up with no VPGs is an error.
+func collectVPGs(ctx context.Context, conn *connection, opts reportOptions) ([]VPG, error) {
+	vpgs, err := conn.queryVPGs(ctx, opts.query())
 	if err != nil {
 		return nil, err
 	}
-
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
+	var matched []VPG
+	for _, vpg := range vpgs {
+		if opts.matches(vpg) {
+			matched = append(matched, vpg)
+		}
+	}
+	if err := checkMatched(conn, opts, len(vpgs), len(matched)); err != nil {
 		return nil, err
 	}
+	if !opts.withPaused && opts.vpgName == "" {
+		matched = excludePaused(conn, matched)
+	}
+	return matched, nil
+}
+
+// excludePaused drops paused VPGs, whose frozen RPO would skew the report,
+// and lists them separately in a warning
+func excludePaused(conn *connection, vpgs []VPG) []VPG {
+	var kept []VPG
+	var paused []string
+	for _, vpg := range vpgs {
+		if vpg.Paused() {
+			paused = append(paused, vpg.VpgName)
+			continue
+		}
+		kept = append(kept, vpg)
+	}
+	warnPaused(conn, paused)
+	return kept
+}
 
-	return &config, nil
+// warnPaused logs the names of the paused VPGs left out of the report, if
+// there are any
+func warnPaused(conn *connection, paused []string) {
+	if len(paused) > 0 {
+		slog.Warn("Excluding paused VPGs; pass -include-paused to report them", "server", conn.ep.host, "count", len(paused), "vpgs", strings.Join(paused, ", "))
+	}
 }
 
-func loginToZerto(client *http.Client, serverIP, username, password string) (string, error) {
-	loginURL := fmt.Sprintf("https://%s:%d/v1/session/add", serverIP, zertoAPIPort)
-	req, _ := http.NewRequest("POST", loginURL, nil)
-	req.SetBasicAuth(username, password)
+// checkMatched applies -fail-on-empty to the number of VPGs the API returned
+// and the number of those matching -filter, warning instead when -filter
+// matches nothing without -fail-on-empty
+func checkMatched(conn *connection, opts reportOptions, returned, matched int) error {
+	if opts.vpgName != "" && matched == 0 {
+		return fmt.Errorf("VPG %q not found", opts.vpgName)
+	}
+	if returned == 0 && opts.failOnEmpty {
+		return errors.New("the Zerto API returned an empty VPG list")
+	}
+	if opts.filter == nil || matched > 0 || returned == 0 {
+		return nil
+	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+	if opts.failOnEmpty {
+		return fmt.Errorf("none of the %d VPGs returned by the Zerto API match -filter %q", returned, opts.filter)
 	}
-	defer resp.Body.Close()
+	slog.Warn("No VPGs match -filter", "server", conn.ep.host, "filter", opts.filter.String(), "returned", returned)
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to login, status code: %d", resp.StatusCode)
+// writeReport renders vpgs to w in the requested format. When thresholds are
+// set it writes a Nagios check result instead and returns its exitStatus.
+func writeReport(w io.Writer, vpgs []VPG, timing apiTiming, opts reportOptions) error {
+	if opts.warn > 0 || opts.crit > 0 {
+		var buf strings.Builder
+		status := writeCheck(&buf, vpgs, opts.warn, opts.crit, opts.maxBreaches)
+		output := buf.String()
+		if opts.color {
+			output = colorize(output, status)
+		}
+		io.WriteString(w, output)
+
+		if status != checkOK {
+			return status
+		}
+		return nil
 	}
 
-	sessionToken := resp.Header.Get("X-Zerto-Session")
-	if sessionToken == "" {
-		return "", errors.New("session token not found in headers")
+	switch opts.format {
+	case formatCSV:
+		if err := writeCSV(w, sortedVPGs(vpgs, opts.sortBy), opts.rowServer()); err != nil {
+			return fmt.Errorf("error writing CSV: %w", err)
+		}
+	case formatJSON:
+		if err := writeJSON(w, vpgs); err != nil {
+			return fmt.Errorf("error writing JSON: %w", err)
+		}
+	case formatNDJSON:
+		if err := writeNDJSON(w, sortedVPGs(vpgs, opts.sortBy), opts.rowServer()); err != nil {
+			return fmt.Errorf("error writing NDJSON: %w", err)
+		}
+	case formatLogfmt:
+		if err := writeLogfmt(w, vpgs, opts); err != nil {
+			return fmt.Errorf("error writing logfmt: %w", err)
+		}
+	case formatTable:
+		if err := writeTable(w, sortedVPGs(vpgs, opts.sortBy), opts); err != nil {
+			return fmt.Errorf("error writing table: %w", err)
+		}
+	case formatProm:
+		if err := writePrometheus(w, vpgs, timing); err != nil {
+			return fmt.Errorf("error writing Prometheus metrics: %w", err)
+		}
+	default:
+		if opts.template != nil {
+			return writeTemplate(w, opts.template, vpgs, opts)
+		}
+		writeText(w, vpgs, opts)
 	}
 
-	return sessionToken, nil
+	return nil
 }
 
-func queryVPGs(client *http.Client, serverIP, sessionToken string) (int, error) {
-	apiURL := fmt.Sprintf("https://%s:%d/v1/vpgs", serverIP, zertoAPIPort)
-	req, _ := http.NewRequest("GET", apiURL, nil)
-	req.Header.Set("X-Zerto-Session", sessionToken)
+// writeText writes the plain-text report selected by the display flags,
+// which by default is just the average RPO
+func writeText(w io.Writer, vpgs []VPG, opts reportOptions) {
+	stats := computeMetricStats(vpgs, opts.metric)
+	switch {
+	case opts.showStatus:
+		writeStatusSummary(w, vpgs)
+	case opts.buckets != nil:
+		writeHistogram(w, vpgs, opts.buckets)
+	case opts.sla:
+		writeSLA(w, vpgs)
+	case opts.over >= 0:
+		writeOver(w, vpgs, opts.over)
+	case opts.top > 0:
+		writeTop(w, vpgs, opts.top)
+	case opts.groupBy != "":
+		writeGroupAverages(w, vpgs, opts.groupBy)
+	case opts.showVMs:
+		total, avg := vmStats(vpgs)
+		fmt.Fprintf(w, "vms=%d avg_per_vm=%s\n", total, formatRPO(avg, opts.unit))
+	case opts.showStats:
+		fmt.Fprintf(w, "avg=%d min=%s max=%s median=%.2f p95=%.2f stddev=%.2f\n", stats.Avg, plainFloat(stats.Min), plainFloat(stats.Max), stats.Median, stats.P95, stats.StdDev)
+	case opts.vpgName != "":
+		for _, vpg := range vpgs {
+			fmt.Fprintf(w, "%s %s\n", formatRPO(vpg.ActualRPO, opts.unit), statusName(vpg.Status))
+		}
+	default:
+		line := formatRPO(averageRPO(vpgs, opts), opts.unit)
+		if opts.precision > 0 {
+			line = formatRPOPrecise(meanRPO(vpgs, opts), opts.unit, opts.precision)
+		}
+		if opts.smoothed != nil {
+			line += " ema=" + formatRPO(int(math.Round(*opts.smoothed)), opts.unit)
+		}
+		if opts.trend != nil {
+			delta := formatRPO(int(opts.trend.delta), opts.unit)
+			if opts.precision > 0 {
+				delta = formatRPOPrecise(opts.trend.delta, opts.unit, opts.precision)
+			}
+			if opts.trend.delta >= 0 {
+				delta = "+" + delta
+			}
+			line += " " + opts.trend.arrow() + " " + delta
+		}
+		if opts.showCount {
+			line += fmt.Sprintf(" (%d %s)", stats.Count, pluralVPGs(stats.Count))
+		}
+		if opts.population > 0 {
+			line += fmt.Sprintf(" (estimate from %d of %d VPGs)", stats.Count, opts.population)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0, err
+// averageRPO returns the average RPO, or other -metric, the default report
+// prints, weighted by priority with -weighted
+func averageRPO(vpgs []VPG, opts reportOptions) int {
+	if opts.weighted {
+		return int(weightedMean(vpgs, opts.metric))
 	}
-	defer resp.Body.Close()
+	return computeMetricStats(vpgs, opts.metric).Avg
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
+// meanRPO is averageRPO without truncating to a whole number
+func meanRPO(vpgs []VPG, opts reportOptions) float64 {
+	if opts.weighted {
+		return weightedMean(vpgs, opts.metric)
 	}
+	return computeMetricStats(vpgs, opts.metric).Mean
+}
 
-	var vpgs []VPG
-	if err := json.Unmarshal(body, &vpgs); err != nil {
-		return 0, fmt.Errorf("error unmarshalling JSON: %v", err)
+// pluralVPGs returns "VPG" or "VPGs" to suit n
+func pluralVPGs(n int) string {
+	if n == 1 {
+		return "VPG"
+	}
+	return "VPGs"
+}
+
+// serverValue picks the servers to query: an explicit -server flag wins over
+// the config file, which wins over the localhost default
+func serverValue(flagValue string, config *Config) string {
+	if isFlagSet("server") {
+		return flagValue
+	}
+	if config.Server != "" {
+		return config.Server
 	}
 
-	if len(vpgs) == 0 {
+	slog.Warn("No server given in -server or config file, using default", "server", defaultServerIP)
+	return defaultServerIP
+}
+
+// portValue picks the API port: an explicit -port flag wins over the config
+// file. It returns 0 when neither is set so the API version's default applies.
+func portValue(flagValue int, config *Config) (int, error) {
+	port := config.Port
+	if isFlagSet("port") {
+		port = flagValue
+	} else if port == 0 {
 		return 0, nil
 	}
 
-	totalRPO := 0
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %d: must be between 1 and 65535", port)
+	}
+	return port, nil
+}
+
+// isFlagSet reports whether the named flag was passed on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitServers splits the comma-separated -server value, ignoring blanks
+func splitServers(value string) []string {
+	var servers []string
+	for _, server := range strings.Split(value, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+
+	if len(servers) == 0 {
+		return []string{defaultServerIP}
+	}
+
+	return servers
+}
+
+// writePrefixed copies output to w, prepending prefix to every line
+func writePrefixed(w io.Writer, prefix string, output []byte) {
+	for _, line := range strings.SplitAfter(string(output), "\n") {
+		if line != "" {
+			io.WriteString(w, prefix+line)
+		}
+	}
+}
+
+// writeCSV writes one VpgName,ActualRPO row per VPG after a header row.
+// With a server every row starts with it instead, under the single header
+// reportAll writes for all servers.
+func writeCSV(w io.Writer, vpgs []VPG, server string) error {
+	cw := csv.NewWriter(w)
+	if server == "" {
+		if err := cw.Write(csvHeader(false)); err != nil {
+			return err
+		}
+	}
+
 	for _, vpg := range vpgs {
-		totalRPO += vpg.ActualRPO
+		row := []string{vpg.VpgName, strconv.Itoa(vpg.ActualRPO)}
+		if server != "" {
+			row = append([]string{server}, row...)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvHeader returns the CSV header row, led by a Server column when the
+// report covers several servers
+func csvHeader(perServer bool) []string {
+	if perServer {
+		return []string{"Server", "VpgName", "ActualRPO"}
+	}
+	return []string{"VpgName", "ActualRPO"}
+}
+
+// writeJSON writes the average RPO and VPG count as a single JSON object.
+// The average is null when there are no VPGs.
+func writeJSON(w io.Writer, vpgs []VPG) error {
+	stats := rpo.ComputeStats(vpgs)
+	result := rpoResult{VPGCount: stats.Count}
+	if stats.Count > 0 {
+		result.AverageRPO = &stats.Avg
 	}
 
-	return totalRPO / len(vpgs), nil
+	return json.NewEncoder(w).Encode(result)
 }
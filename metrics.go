@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// metricActualRPO is the default -metric
+const metricActualRPO = "ActualRPO"
+
+// metricFields maps the -metric values to the VPG field they select
+var metricFields = map[string]func(VPG) float64{
+	metricActualRPO:          func(v VPG) float64 { return float64(v.ActualRPO) },
+	"ThroughputInMB":         func(v VPG) float64 { return v.ThroughputInMB },
+	"IOPS":                   func(v VPG) float64 { return float64(v.IOPS) },
+	"ProvisionedStorageInMB": func(v VPG) float64 { return float64(v.ProvisionedStorageInMB) },
+}
+
+// metricValue returns the given -metric field of vpg, defaulting to
+// ActualRPO
+func metricValue(vpg VPG, metric string) float64 {
+	field, ok := metricFields[metric]
+	if !ok {
+		field = metricFields[metricActualRPO]
+	}
+	return field(vpg)
+}
+
+// metricNames lists the valid -metric values for error messages
+func metricNames() string {
+	names := make([]string, 0, len(metricFields))
+	for name := range metricFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// streamNDJSON writes each VPG as a JSON object on its own line, page by
+// page as the API returns them, so that large sites are never held in
+// memory at once. Output is flushed after every page and on return, so a
+// query that fails midway still leaves every VPG seen so far written.
+// Paused VPGs are left out as collectVPGs does.
+func streamNDJSON(ctx context.Context, w io.Writer, conn *connection, opts reportOptions) error {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	enc := json.NewEncoder(bw)
+	server := ""
+	if opts.perServer {
+		server = conn.name()
+	}
+	returned, matched := 0, 0
+	var paused []string
+	err := conn.eachVPGPage(ctx, opts.query(), func(batch []VPG) error {
+		returned += len(batch)
+		for _, vpg := range batch {
+			if !opts.matches(vpg) {
+				continue
+			}
+			matched++
+			if !opts.withPaused && opts.vpgName == "" && vpg.Paused() {
+				paused = append(paused, vpg.VpgName)
+				continue
+			}
+			if err := encodeNDJSON(enc, vpg, server); err != nil {
+				return fmt.Errorf("error writing NDJSON: %w", err)
+			}
+		}
+		return bw.Flush()
+	})
+	warnPaused(conn, paused)
+	if err != nil {
+		return err
+	}
+
+	return checkMatched(conn, opts, returned, matched)
+}
+
+// writeNDJSON writes each VPG as a JSON object on its own line, for when
+// the VPGs have to be collected first, as with -sort. A non-empty server is
+// added to every object.
+func writeNDJSON(w io.Writer, vpgs []VPG, server string) error {
+	enc := json.NewEncoder(w)
+	for _, vpg := range vpgs {
+		if err := encodeNDJSON(enc, vpg, server); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serverVPG is a VPG in a multi-server ndjson report, tagged with the
+// server it came from so that the lines of each server stay apart
+type serverVPG struct {
+	Server string `json:"server"`
+	VPG
+}
+
+// encodeNDJSON writes vpg as one line, as a serverVPG when server is set
+func encodeNDJSON(enc *json.Encoder, vpg VPG, server string) error {
+	if server == "" {
+		return enc.Encode(vpg)
+	}
+	return enc.Encode(serverVPG{Server: server, VPG: vpg})
+}
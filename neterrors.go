@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// friendlyError replaces the message of a low-level network error with one
+// an operator can act on, while keeping the original for errors.Is/As
+type friendlyError struct {
+	msg string
+	err error
+}
+
+func (e *friendlyError) Error() string {
+	return e.msg
+}
+
+func (e *friendlyError) Unwrap() error {
+	return e.err
+}
+
+// alertProtocolVersion is the TLS alert a server sends when none of the
+// protocol versions the client offers are acceptable to it
+const alertProtocolVersion tls.AlertError = 70
+
+// describeNetError turns DNS, connection and TLS handshake failures in
+// reaching ep into plain explanations. Other errors are returned unchanged.
+// The raw error is logged at debug level for -verbose.
+func describeNetError(ep endpoint, err error) error {
+	addr := ep.host
+	if u, perr := url.Parse(ep.url("")); perr == nil {
+		addr = u.Host
+	}
+
+	var (
+		dnsErr      *net.DNSError
+		netErr      net.Error
+		unknownCA   x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		verifyErr   *tls.CertificateVerificationError
+		recordErr   tls.RecordHeaderError
+		alertErr    tls.AlertError
+	)
+	var msg string
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &dnsErr):
+		msg = fmt.Sprintf("cannot resolve ZVM host %s — check the hostname and DNS", ep.host)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		msg = fmt.Sprintf("cannot reach ZVM at %s — connection refused, check the hostname, port and network", addr)
+	case errors.As(err, &verifyErr), errors.As(err, &unknownCA), errors.As(err, &hostnameErr):
+		msg = fmt.Sprintf("TLS handshake with ZVM at %s failed — the certificate could not be verified, check -cacert", addr)
+	case errors.As(err, &alertErr) && alertErr == alertProtocolVersion,
+		strings.Contains(err.Error(), "protocol version not supported"),
+		strings.Contains(err.Error(), "unsupported protocol version"):
+		msg = fmt.Sprintf("TLS handshake with ZVM at %s failed — the server only offers TLS versions older than -tls-min allows", addr)
+	case errors.As(err, &recordErr):
+		msg = fmt.Sprintf("TLS handshake with ZVM at %s failed — the server did not respond with TLS, check the port", addr)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		msg = fmt.Sprintf("cannot reach ZVM at %s — timed out, check the hostname and network", addr)
+	default:
+		return err
+	}
+
+	slog.Debug("Connection to ZVM failed", "server", addr, "error", err)
+	return &friendlyError{msg: msg, err: err}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// Defaults for the connection pool flags. They favour keeping the
+// connection to each ZVM open between -interval polls, so a poll doesn't pay
+// for a new TCP and TLS handshake.
+const (
+	defaultMaxIdleConns    = 10
+	defaultIdleConnTimeout = 5 * time.Minute
+)
+
+// maxDrainBytes bounds how much of an unread response body closeBody
+// discards to keep the connection reusable
+const maxDrainBytes = 64 << 10
+
+// poolOptions configures how the transport keeps connections open between
+// requests
+type poolOptions struct {
+	maxIdleConns      int
+	idleConnTimeout   time.Duration
+	disableKeepAlives bool
+}
+
+// apply sets the pool options on t. MaxIdleConnsPerHost follows
+// MaxIdleConns because every request goes to a handful of ZVMs, and the
+// http.Transport default of 2 per host would otherwise cap reuse.
+func (p poolOptions) apply(t *http.Transport) {
+	t.MaxIdleConns = p.maxIdleConns
+	t.MaxIdleConnsPerHost = p.maxIdleConns
+	t.IdleConnTimeout = p.idleConnTimeout
+	t.DisableKeepAlives = p.disableKeepAlives
+}
+
+// closeBody discards what's left of body before closing it. The transport
+// only returns a connection to the pool once its response has been read to
+// the end, which a JSON decoder stopping at the closing brace doesn't do.
+func closeBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
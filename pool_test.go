@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPoolReusesConnections(t *testing.T) {
+	tests := []struct {
+		name string
+		pool poolOptions
+		want int32 // TLS connections for five queries
+	}{
+		{"defaults", poolOptions{maxIdleConns: defaultMaxIdleConns, idleConnTimeout: defaultIdleConnTimeout}, 1},
+		{"no keep-alive", poolOptions{maxIdleConns: defaultMaxIdleConns, idleConnTimeout: defaultIdleConnTimeout, disableKeepAlives: true}, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zvm := &mockZVM{username: mockUsername, password: mockPassword, vpgs: `[{"VpgName":"web","ActualRPO":10}]`}
+			zvm.Server = httptest.NewUnstartedServer(http.HandlerFunc(zvm.serveHTTP))
+			var handshakes atomic.Int32
+			zvm.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					handshakes.Add(1)
+				}
+			}
+			zvm.StartTLS()
+			t.Cleanup(zvm.Close)
+
+			client := zvm.Client()
+			tt.pool.apply(client.Transport.(*http.Transport))
+			for i := 0; i < 5; i++ {
+				if _, _, err := queryVPGPage(context.Background(), client, zvm.endpoint(t), session{token: mockToken}, 1, vpgQuery{}); err != nil {
+					t.Fatalf("query %d: %v", i+1, err)
+				}
+			}
+
+			if n := handshakes.Load(); n != tt.want {
+				t.Errorf("%d connections for five queries, want %d", n, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/brookwarren/zerto-rpo/rpo"
+)
+
+// promLabelEscaper escapes label values per the Prometheus text format
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePrometheus writes per-VPG and average RPO gauges in the Prometheus
+// text exposition format, suitable for the node_exporter textfile collector,
+// along with how long the API calls behind them took
+func writePrometheus(w io.Writer, vpgs []VPG, timing apiTiming) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "# HELP zerto_vpg_actual_rpo_seconds Actual RPO of the VPG in seconds.")
+	fmt.Fprintln(bw, "# TYPE zerto_vpg_actual_rpo_seconds gauge")
+	for _, vpg := range vpgs {
+		fmt.Fprintf(bw, "zerto_vpg_actual_rpo_seconds{vpg=\"%s\"} %d\n", promLabelEscaper.Replace(vpg.VpgName), vpg.ActualRPO)
+	}
+
+	fmt.Fprintln(bw, "# HELP zerto_vpg_rpo_average_seconds Average actual RPO across all VPGs in seconds.")
+	fmt.Fprintln(bw, "# TYPE zerto_vpg_rpo_average_seconds gauge")
+	fmt.Fprintf(bw, "zerto_vpg_rpo_average_seconds %d\n", rpo.ComputeStats(vpgs).Avg)
+
+	if timing.login > 0 {
+		fmt.Fprintln(bw, "# HELP zerto_api_login_duration_seconds Time taken to log in to the Zerto API.")
+		fmt.Fprintln(bw, "# TYPE zerto_api_login_duration_seconds gauge")
+		fmt.Fprintf(bw, "zerto_api_login_duration_seconds %g\n", timing.login.Seconds())
+	}
+	fmt.Fprintln(bw, "# HELP zerto_api_query_duration_seconds Time taken to query the VPGs from the Zerto API.")
+	fmt.Fprintln(bw, "# TYPE zerto_api_query_duration_seconds gauge")
+	fmt.Fprintf(bw, "zerto_api_query_duration_seconds %g\n", timing.query.Seconds())
+
+	return bw.Flush()
+}
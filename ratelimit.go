@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultDetailRate is the default -rate. Together with the detailWorkers
+// pool it keeps the per-VPG detail calls well under what a typical ZVM
+// serves alongside its replication work; raise it for large sites on a
+// dedicated ZVM appliance, or lower it if the ZVM UI becomes sluggish
+// during -journal or -test-age runs.
+const defaultDetailRate = 10
+
+// rateLimiter is a token bucket holding up to burst tokens, refilled at
+// rate per second. A nil *rateLimiter doesn't limit.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing perSecond requests a second
+// with bursts of up to burst, or nil when perSecond is not positive
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: perSecond, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until a token is available or ctx is done. Each caller
+// reserves its token up front, so waiters are served in arrival order
+// without polling.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
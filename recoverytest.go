@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// vpgTest is when a VPG last had a recovery (failover) test
+type vpgTest struct {
+	VPG      VPG
+	LastTest time.Time // zero if the VPG has never been tested
+	Err      error
+}
+
+// vpgDetails is the part of the single-VPG response holding the last test
+type vpgDetails struct {
+	LastTest *time.Time `json:"LastTest"`
+}
+
+// queryLastTests fetches the last recovery test time of every VPG, in the
+// same way and with the same ordering and error handling as
+// queryVPGStatuses
+func queryLastTests(ctx context.Context, client *http.Client, ep endpoint, sess session, vpgs []VPG, limit *rateLimiter) []vpgTest {
+	tests := make([]vpgTest, len(vpgs))
+	forEachVPG(ctx, len(vpgs), limit, func(i int) {
+		last, err := queryLastTest(ctx, client, ep, sess, vpgs[i].VpgIdentifier)
+		tests[i] = vpgTest{VPG: vpgs[i], LastTest: last, Err: err}
+	})
+	return tests
+}
+
+// queryLastTest returns the time of the last recovery test of the VPG with
+// the given identifier
+func queryLastTest(ctx context.Context, client *http.Client, ep endpoint, sess session, vpgID string) (time.Time, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", ep.url("v1/vpgs/"+url.PathEscape(vpgID)), nil)
+	sess.authorize(req, ep)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer closeBody(resp.Body)
+
+	if sessionRejected(resp.StatusCode) {
+		return time.Time{}, ErrSessionExpired
+	}
+	if err := expectJSON(resp); err != nil {
+		return time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("failed to query VPG, status code: %d", resp.StatusCode)
+	}
+
+	var details vpgDetails
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		return time.Time{}, fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+	if details.LastTest == nil {
+		return time.Time{}, nil
+	}
+
+	return *details.LastTest, nil
+}
+
+// writeStaleTests writes each VPG whose last recovery test is older than
+// maxAge relative to now, or which has never been tested
+func writeStaleTests(w io.Writer, tests []vpgTest, maxAge time.Duration, now time.Time) {
+	stale := 0
+	for _, t := range tests {
+		switch {
+		case t.Err != nil:
+			fmt.Fprintf(w, "%s: error: %v\n", t.VPG.VpgName, t.Err)
+		case t.LastTest.IsZero():
+			fmt.Fprintf(w, "%s: never tested\n", t.VPG.VpgName)
+		case now.Sub(t.LastTest) > maxAge:
+			age := now.Sub(t.LastTest).Round(time.Second)
+			fmt.Fprintf(w, "%s: last tested %s (%v ago)\n", t.VPG.VpgName, t.LastTest.Format(time.RFC3339), age)
+		default:
+			continue
+		}
+		stale++
+	}
+
+	if stale == 0 {
+		fmt.Fprintf(w, "All VPGs tested within %v\n", maxAge)
+	}
+}
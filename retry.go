@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryBaseDelay is the wait before the first retry; it doubles per attempt
+const retryBaseDelay = 500 * time.Millisecond
+
+// retryTransport retries requests that fail with a network error, a 5xx or a
+// 429 response, backing off exponentially between attempts. A Retry-After
+// header overrides the backoff for that attempt. Because it sits
+// below http.Client, the client's Timeout bounds the whole sequence and
+// cancels any pending backoff once exceeded.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.retries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		wait := delay
+		if resp != nil {
+			if after, ok := retryAfter(resp, time.Now()); ok {
+				wait = after
+			}
+			closeBody(resp.Body)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// shouldRetry reports whether a round trip outcome is worth retrying. 4xx
+// responses other than 429 Too Many Requests are returned immediately since
+// repeating them won't help.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryAfter returns how long the Retry-After header of resp asks to wait,
+// given as either delay seconds or an HTTP date. A date in the past means
+// no wait.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
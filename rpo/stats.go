@@ -0,0 +1,84 @@
+package rpo
+
+import (
+	"math"
+	"sort"
+)
+
+// Stats holds summary statistics over the ActualRPO, or another numeric
+// field, of a set of VPGs
+type Stats struct {
+	Count  int
+	Avg    int     // truncated to a whole number
+	Mean   float64 // exact average
+	Min    float64
+	Max    float64
+	Median float64
+	P95    float64
+	StdDev float64 // population standard deviation
+}
+
+// ComputeStats calculates summary statistics over the ActualRPO of vpgs.
+// All values are zero when there are no VPGs.
+func ComputeStats(vpgs []VPG) Stats {
+	values := make([]float64, len(vpgs))
+	for i, vpg := range vpgs {
+		values[i] = float64(vpg.ActualRPO)
+	}
+	return StatsOf(values)
+}
+
+// StatsOf calculates summary statistics over values, such as one field of
+// each VPG. All values are zero when values is empty. values is not
+// modified.
+func StatsOf(values []float64) Stats {
+	var stats Stats
+	if len(values) == 0 {
+		return stats
+	}
+
+	// Welford's algorithm gives the variance in a single, numerically
+	// stable pass: mean is the running mean and m2 the running sum of
+	// squared deviations from it
+	total := 0.0
+	mean, m2 := 0.0, 0.0
+	for i, x := range values {
+		total += x
+
+		delta := x - mean
+		mean += delta / float64(i+1)
+		m2 += delta * (x - mean)
+	}
+
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	stats.Count = len(values)
+	stats.Avg = int(total / float64(len(values)))
+	stats.Mean = mean
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	stats.StdDev = math.Sqrt(m2 / float64(len(values)))
+	stats.Median = percentile(sorted, 50)
+	stats.P95 = percentile(sorted, 95)
+	return stats
+}
+
+// percentile returns the p-th percentile (0-100) of the ascending values
+// using linear interpolation between closest ranks: the rank is
+// p/100*(n-1), and a fractional rank interpolates between the two values
+// either side of it. This is the same as numpy's default "linear" method.
+// An empty slice yields 0.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+
+	frac := rank - float64(lower)
+	return sorted[lower] + frac*(sorted[lower+1]-sorted[lower])
+}
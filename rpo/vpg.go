@@ -0,0 +1,47 @@
+// Package rpo holds the Zerto VPG data model and the RPO statistics that
+// the zerto-rpo command reports, for use by other Go programs.
+package rpo
+
+// VPG represents the VPG details returned by the Zerto API
+type VPG struct {
+	VpgIdentifier string   `json:"VpgIdentifier"`
+	VpgName       string   `json:"VpgName"`
+	ActualRPO     int      `json:"ActualRPO"`
+	Status        int      `json:"Status"`
+	SubStatus     int      `json:"SubStatus"`
+	TargetSite    string   `json:"TargetSite"`
+	Priority      int      `json:"Priority"`
+	VmsCount      int      `json:"VmsCount"`
+	Tags          []string `json:"Tags"`
+
+	// ConfiguredRpoThresholdInSeconds is the RPO target set on the VPG, or
+	// 0 when the ZVM doesn't report one
+	ConfiguredRpoThresholdInSeconds int `json:"ConfiguredRpoThresholdInSeconds"`
+
+	ThroughputInMB         float64 `json:"ThroughputInMB"`
+	IOPS                   int     `json:"IOPS"`
+	ProvisionedStorageInMB int     `json:"ProvisionedStorageInMB"`
+}
+
+// VPG SubStatus codes for replication that has been paused, by a user or
+// by the ZVM itself. The Status of a paused VPG doesn't change, and its
+// ActualRPO stays frozen at the value it had when paused.
+const (
+	SubStatusPausedByUser   = 25
+	SubStatusPausedBySystem = 26
+)
+
+// Paused reports whether replication of the VPG is paused
+func (v VPG) Paused() bool {
+	return v.SubStatus == SubStatusPausedByUser || v.SubStatus == SubStatusPausedBySystem
+}
+
+// TargetRatio returns the actual RPO as a multiple of the configured target,
+// so that values above 1 are breaching it. ok is false when the VPG has no
+// configured target.
+func (v VPG) TargetRatio() (ratio float64, ok bool) {
+	if v.ConfiguredRpoThresholdInSeconds <= 0 {
+		return 0, false
+	}
+	return float64(v.ActualRPO) / float64(v.ConfiguredRpoThresholdInSeconds), true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// sampleVPGs returns n VPGs picked at random without replacement from
+// vpgs, in their original order, or vpgs itself when it has no more than n.
+// The same seed picks the same positions, so a run can be reproduced.
+//
+// Statistics over a sample are estimates: the standard error of the average
+// is roughly stddev/sqrt(n), shrinking by sqrt((len(vpgs)-n)/(len(vpgs)-1))
+// as the sample covers more of the site, and the minimum, maximum and p95
+// can miss the outliers entirely. Use -sample for a quick pulse, not for
+// thresholds or SLA reporting.
+func sampleVPGs(vpgs []VPG, n int, seed uint64) []VPG {
+	if n <= 0 || len(vpgs) <= n {
+		return vpgs
+	}
+
+	r := rand.New(rand.NewPCG(seed, 0))
+	picked := r.Perm(len(vpgs))[:n]
+	slices.Sort(picked)
+
+	sample := make([]VPG, n)
+	for i, index := range picked {
+		sample[i] = vpgs[index]
+	}
+	return sample
+}
+
+// sampleSeed returns the -seed to use, choosing one from the clock when it
+// wasn't given and logging it so that the sample can be repeated
+func sampleSeed(seed uint64, given bool) uint64 {
+	if !given {
+		seed = uint64(time.Now().UnixNano())
+		slog.Debug("Sampling with a random seed", "seed", seed)
+	}
+	return seed
+}
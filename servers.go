@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// serverAddr is a ZVM to query. port is 0 unless one was given with the
+// address, in which case it overrides -port for that server.
+type serverAddr struct {
+	host string
+	port int
+}
+
+// readServerFile reads the servers to query from path, one IP or ip:port
+// per line. Blank lines and # comments are ignored. A malformed line is
+// logged with its line number and skipped rather than failing the run.
+func readServerFile(path string) ([]serverAddr, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var servers []serverAddr
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		server, err := parseServerAddr(line)
+		if err != nil {
+			slog.Warn("Skipping malformed line in -server-file", "file", path, "line", lineNo, "error", err)
+			continue
+		}
+		servers = append(servers, server)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers listed in %s", path)
+	}
+	return servers, nil
+}
+
+// parseServerAddr parses a server given as "host" or "host:port". An
+// address with several colons and no brackets is taken as a bare IPv6
+// address, and an IPv6 address may also be bracketed, with or without a
+// port, as in "[::1]" or "[::1]:9669". A URL or anything else with a
+// scheme or path is rejected with the bare host it probably meant.
+func parseServerAddr(s string) (serverAddr, error) {
+	if _, rest, ok := strings.Cut(s, "://"); ok {
+		return serverAddr{}, fmt.Errorf("give the host without a scheme, as in %q", trimPath(rest))
+	}
+	if strings.ContainsAny(s, "/?#@") {
+		return serverAddr{}, fmt.Errorf("give just the host or host:port, as in %q", trimPath(s))
+	}
+	if strings.ContainsAny(s, " \t,") {
+		return serverAddr{}, fmt.Errorf("invalid server %q", s)
+	}
+	if strings.Count(s, ":") > 1 && !strings.HasPrefix(s, "[") {
+		return serverAddr{host: s}, nil
+	}
+	if !strings.Contains(s, ":") || strings.HasSuffix(s, "]") {
+		return serverAddr{host: unbracketHost(s)}, nil
+	}
+
+	host, portText, err := net.SplitHostPort(s)
+	if err != nil {
+		return serverAddr{}, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return serverAddr{}, fmt.Errorf("invalid port %q: must be between 1 and 65535", portText)
+	}
+	if host == "" {
+		return serverAddr{}, errors.New("missing host")
+	}
+	return serverAddr{host: host, port: port}, nil
+}
+
+// trimPath cuts a URL-ish server value down to its host[:port], dropping any
+// user info and everything from the path on
+func trimPath(s string) string {
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+// unbracketHost strips the brackets from an IPv6 literal such as "[::1]".
+// Hosts are kept without brackets and bracketed again when building URLs.
+func unbracketHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseServerAddr(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    serverAddr
+		wantErr string // substring of the error, if one is expected
+	}{
+		{in: "10.0.0.1", want: serverAddr{host: "10.0.0.1"}},
+		{in: "zvm.example.com", want: serverAddr{host: "zvm.example.com"}},
+		{in: "10.0.0.1:9443", want: serverAddr{host: "10.0.0.1", port: 9443}},
+		{in: "zvm.example.com:9669", want: serverAddr{host: "zvm.example.com", port: 9669}},
+		{in: "::1", want: serverAddr{host: "::1"}},
+		{in: "[::1]", want: serverAddr{host: "::1"}},
+		{in: "[::1]:9669", want: serverAddr{host: "::1", port: 9669}},
+
+		{in: "http://10.0.0.1", wantErr: `as in "10.0.0.1"`},
+		{in: "https://zvm.example.com:9669/v1/vpgs", wantErr: `as in "zvm.example.com:9669"`},
+		{in: "https://admin@zvm.example.com", wantErr: `as in "zvm.example.com"`},
+		{in: "10.0.0.1/v1", wantErr: `as in "10.0.0.1"`},
+		{in: "admin@10.0.0.1", wantErr: `as in "10.0.0.1"`},
+		{in: "10.0.0.1:99999", wantErr: "between 1 and 65535"},
+		{in: "10.0.0.1:https", wantErr: "between 1 and 65535"},
+		{in: ":9669", wantErr: "missing host"},
+		{in: "10.0.0.1 10.0.0.2", wantErr: "invalid server"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseServerAddr(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseServerAddr(%q) error = %v, want one containing %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseServerAddr(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseServerAddr(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
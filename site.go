@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// Supported values for the -source flag
+const (
+	sourceVPGs = "vpgs"
+	sourceSite = "site"
+)
+
+// peerSite is a site paired with the ZVM, as listed by v1/peersites, along
+// with the RPO the ZVM reports for the pairing as a whole
+type peerSite struct {
+	PeerSiteName   string `json:"PeerSiteName"`
+	SiteIdentifier string `json:"SiteIdentifier"`
+	ActualRPO      int    `json:"ActualRPO"`
+}
+
+// querySiteRPO fetches the peer sites of the ZVM. The ZVM keeps the
+// pairing RPO itself, so this is a single small request however many VPGs
+// there are.
+func querySiteRPO(ctx context.Context, client *http.Client, ep endpoint, sess session) ([]peerSite, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", ep.url("v1/peersites"), nil)
+	sess.authorize(req, ep)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	if sessionRejected(resp.StatusCode) {
+		return nil, ErrSessionExpired
+	}
+	if err := expectJSON(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query peer sites, status code: %d", resp.StatusCode)
+	}
+
+	var sites []peerSite
+	if err := json.NewDecoder(resp.Body).Decode(&sites); err != nil {
+		return nil, fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+	return sites, nil
+}
+
+// siteAverageRPO returns the average of the pairing RPOs of sites
+func siteAverageRPO(sites []peerSite) int {
+	if len(sites) == 0 {
+		return 0
+	}
+	total := 0
+	for _, site := range sites {
+		total += site.ActualRPO
+	}
+	return total / len(sites)
+}
+
+// reportSiteRPO writes the average RPO reported for the ZVM's peer sites,
+// for -source site. With -verbose it also computes the average over the
+// VPGs and logs both, so that any discrepancy between them shows up.
+func reportSiteRPO(ctx context.Context, w io.Writer, conn *connection, opts reportOptions) error {
+	sites, err := conn.querySiteRPO(ctx)
+	if err != nil {
+		return err
+	}
+	if len(sites) == 0 {
+		return queryError(fmt.Errorf("%s reports no peer sites", conn.name()))
+	}
+	average := siteAverageRPO(sites)
+
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		vpgs, err := collectVPGs(ctx, conn, opts)
+		if err != nil {
+			slog.Debug("Could not compute the VPG average to compare with the site RPO", "server", conn.ep.host, "error", err)
+		} else {
+			slog.Debug("RPO by source", "server", conn.ep.host, "site", average, "vpgs", averageRPO(vpgs, opts), "sites", len(sites), "vpg_count", len(vpgs))
+		}
+	}
+
+	fmt.Fprintln(w, formatRPO(average, opts.unit))
+	return nil
+}
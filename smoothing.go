@@ -0,0 +1,21 @@
+package main
+
+// ema is an exponential moving average of the RPO across watch-mode polls,
+// used to damp transient spikes. Each connection keeps its own.
+type ema struct {
+	alpha  float64
+	value  float64
+	primed bool
+}
+
+// update folds x into the average and returns the new value. The first
+// sample is taken as is.
+func (e *ema) update(x float64) float64 {
+	if !e.primed {
+		e.value = x
+		e.primed = true
+	} else {
+		e.value = e.alpha*x + (1-e.alpha)*e.value
+	}
+	return e.value
+}
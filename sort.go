@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Supported values for the -sort flag
+const (
+	sortName    = "name"
+	sortRPO     = "rpo"
+	sortRPODesc = "-rpo"
+)
+
+// validSortOrder reports an error if by is not a -sort value. An empty
+// order keeps the API order.
+func validSortOrder(by string) error {
+	switch by {
+	case "", sortName, sortRPO, sortRPODesc:
+		return nil
+	default:
+		return fmt.Errorf("unknown -sort %q: must be %s, %s or %s", by, sortName, sortRPO, sortRPODesc)
+	}
+}
+
+// sortedVPGs returns a copy of vpgs in the -sort order, or vpgs itself in
+// API order when by is empty. Ties are broken by name, and names by RPO, so
+// the order is stable across runs.
+func sortedVPGs(vpgs []VPG, by string) []VPG {
+	if by == "" {
+		return vpgs
+	}
+
+	sorted := append([]VPG(nil), vpgs...)
+	switch by {
+	case sortName:
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].VpgName != sorted[j].VpgName {
+				return sorted[i].VpgName < sorted[j].VpgName
+			}
+			return sorted[i].ActualRPO < sorted[j].ActualRPO
+		})
+	case sortRPO:
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].ActualRPO != sorted[j].ActualRPO {
+				return sorted[i].ActualRPO < sorted[j].ActualRPO
+			}
+			return sorted[i].VpgName < sorted[j].VpgName
+		})
+	case sortRPODesc:
+		sortByRPODesc(sorted)
+	}
+	return sorted
+}
@@ -0,0 +1,57 @@
+package main
+
+import "github.com/brookwarren/zerto-rpo/rpo"
+
+// computeMetricStats calculates summary statistics over the given -metric
+// field of vpgs
+func computeMetricStats(vpgs []VPG, metric string) rpo.Stats {
+	values := make([]float64, len(vpgs))
+	for i, vpg := range vpgs {
+		values[i] = metricValue(vpg, metric)
+	}
+	return rpo.StatsOf(values)
+}
+
+// priorityWeights maps the Zerto VPG Priority (0 Low, 1 Medium, 2 High) to
+// its weight in the weighted average, doubling per level so that one High
+// VPG counts as much as four Low ones. Unknown priorities weigh as Low.
+var priorityWeights = map[int]int{
+	0: 1,
+	1: 2,
+	2: 4,
+}
+
+// weightedMean is the priority-weighted mean of the given -metric field,
+// without truncating to a whole number
+func weightedMean(vpgs []VPG, metric string) float64 {
+	total, totalWeight := 0.0, 0
+	for _, vpg := range vpgs {
+		weight, ok := priorityWeights[vpg.Priority]
+		if !ok {
+			weight = priorityWeights[0]
+		}
+		total += float64(weight) * metricValue(vpg, metric)
+		totalWeight += weight
+	}
+
+	if totalWeight == 0 {
+		return 0
+	}
+	return total / float64(totalWeight)
+}
+
+// vmStats returns the total number of protected VMs and the mean ActualRPO
+// per VM, i.e. each VPG's RPO weighted by its VmsCount. Older APIs omit
+// VmsCount, leaving it zero; the average is then 0 too.
+func vmStats(vpgs []VPG) (total, avg int) {
+	totalRPO := 0
+	for _, vpg := range vpgs {
+		total += vpg.VmsCount
+		totalRPO += vpg.VmsCount * vpg.ActualRPO
+	}
+
+	if total == 0 {
+		return 0, 0
+	}
+	return total, totalRPO / total
+}
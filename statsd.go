@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// defaultStatsDPrefix is the default -statsd-prefix
+const defaultStatsDPrefix = "zerto.rpo"
+
+// maxStatsDPacket keeps each datagram within a typical Ethernet MTU so it
+// isn't fragmented on the way to the StatsD server
+const maxStatsDPacket = 1432
+
+// statsdSink pushes RPO gauges to a StatsD server over UDP for -statsd
+type statsdSink struct {
+	addr   string
+	prefix string
+	perVPG bool // also send a gauge per VPG
+}
+
+// send pushes the average RPO as the <prefix>.avg gauge and, with perVPG,
+// each VPG's RPO as <prefix>.vpg.<name>. Metrics are best-effort: a failure
+// is logged and otherwise ignored.
+func (s *statsdSink) send(vpgs []VPG, average int) {
+	lines := []string{fmt.Sprintf("%s.avg:%d|g", s.prefix, average)}
+	if s.perVPG {
+		for _, vpg := range vpgs {
+			lines = append(lines, fmt.Sprintf("%s.vpg.%s:%d|g", s.prefix, statsdName(vpg.VpgName), vpg.ActualRPO))
+		}
+	}
+
+	conn, err := net.Dial("udp", s.addr)
+	if err != nil {
+		slog.Warn("Failed to send StatsD metrics", "addr", s.addr, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	for _, packet := range statsdPackets(lines) {
+		if _, err := conn.Write([]byte(packet)); err != nil {
+			slog.Warn("Failed to send StatsD metrics", "addr", s.addr, "error", err)
+			return
+		}
+	}
+	slog.Debug("Sent StatsD metrics", "addr", s.addr, "metrics", len(lines))
+}
+
+// statsdPackets joins lines into newline-separated packets of at most
+// maxStatsDPacket bytes, except for a single line that is longer by itself
+func statsdPackets(lines []string) []string {
+	var packets []string
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxStatsDPacket {
+			packets = append(packets, packet.String())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		packets = append(packets, packet.String())
+	}
+	return packets
+}
+
+// statsdName makes a VPG name usable as one StatsD metric name segment,
+// replacing the characters StatsD treats specially (. : | @ #), whitespace
+// and control characters with _
+func statsdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(".:|@#", r) || r <= ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+}
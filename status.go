@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// vpgStatusNames maps the Zerto VPG Status codes to their API names
+var vpgStatusNames = map[int]string{
+	0: "Initializing",
+	1: "MeetingSLA",
+	2: "NotMeetingSLA",
+	3: "RpoNotMeetingSLA",
+	4: "HistoryNotMeetingSLA",
+	5: "FailingOver",
+	6: "Moving",
+	7: "Deleting",
+	8: "Recovered",
+}
+
+// Health categories that VPG statuses are summarised into
+const (
+	healthHealthy = "Healthy"
+	healthWarning = "Warning"
+	healthError   = "Error"
+)
+
+// statusName returns the human-readable name of a VPG status code
+func statusName(status int) string {
+	if name, ok := vpgStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%d)", status)
+}
+
+// statusHealth buckets a VPG status code into a health category. Only
+// MeetingSLA is healthy; SLA breaches are errors and transitional states
+// such as initial sync or failover are warnings, since their RPO is stale.
+func statusHealth(status int) string {
+	switch status {
+	case 1:
+		return healthHealthy
+	case 2, 3:
+		return healthError
+	default:
+		return healthWarning
+	}
+}
+
+// writeStatusSummary writes a count of VPGs per health category followed by
+// the name and status of each VPG that isn't healthy
+func writeStatusSummary(w io.Writer, vpgs []VPG) {
+	counts := make(map[string]int)
+	for _, vpg := range vpgs {
+		counts[statusHealth(vpg.Status)]++
+	}
+
+	fmt.Fprintf(w, "%s: %d, %s: %d, %s: %d\n",
+		healthHealthy, counts[healthHealthy],
+		healthWarning, counts[healthWarning],
+		healthError, counts[healthError])
+
+	for _, vpg := range vpgs {
+		if statusHealth(vpg.Status) != healthHealthy {
+			fmt.Fprintf(w, "%s: %s\n", vpg.VpgName, statusName(vpg.Status))
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// writeTable writes an aligned table of each VPG's name, RPO and status
+// with a footer row holding the average. tabwriter can only right-align
+// every column, so the RPO column is padded to its widest value here.
+func writeTable(w io.Writer, vpgs []VPG, opts reportOptions) error {
+	rpos := make([]string, len(vpgs))
+	average := formatRPO(averageRPO(vpgs, opts), opts.unit)
+	width := max(len("RPO"), len(average))
+	for i, vpg := range vpgs {
+		rpos[i] = formatRPO(vpg.ActualRPO, opts.unit)
+		width = max(width, len(rpos[i]))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "VPG\t%*s\tSTATUS\n", width, "RPO")
+	for i, vpg := range vpgs {
+		fmt.Fprintf(tw, "%s\t%*s\t%s\n", vpg.VpgName, width, rpos[i], statusName(vpg.Status))
+	}
+	fmt.Fprintf(tw, "Average\t%*s\n", width, average)
+	return tw.Flush()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/brookwarren/zerto-rpo/rpo"
+)
+
+// templateData is what a -template is executed against. The embedded
+// statistics give {{.Avg}}, {{.Count}} and the rest, and {{range .VPGs}}
+// reaches the individual VPGs.
+type templateData struct {
+	rpo.Stats
+	VPGs []VPG
+}
+
+// parseOutputTemplate parses a -template and executes it once against a
+// single placeholder VPG, so a misspelled field fails before any API call
+// rather than after the query, while {{(index .VPGs 0)}} still passes
+func parseOutputTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	placeholder := []VPG{{VpgName: "placeholder"}}
+	if err := tmpl.Execute(io.Discard, templateData{Stats: rpo.ComputeStats(placeholder), VPGs: placeholder}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// writeTemplate executes the -template against the statistics of vpgs,
+// ending the output with a newline if the template doesn't. Avg follows
+// -weighted like the default output.
+func writeTemplate(w io.Writer, tmpl *template.Template, vpgs []VPG, opts reportOptions) error {
+	data := templateData{Stats: computeMetricStats(vpgs, opts.metric), VPGs: vpgs}
+	data.Avg = averageRPO(vpgs, opts)
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("error executing -template: %w", err)
+	}
+	output := buf.String()
+	if !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+	_, err := io.WriteString(w, output)
+	return err
+}
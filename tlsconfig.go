@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// buildTLSConfig returns the TLS settings for talking to the ZVM. With a CA
+// bundle the server certificate is verified against it. Without one,
+// verification is skipped when insecure is set, as ZVMs commonly use
+// self-signed certificates, and done against the system roots otherwise.
+// A client certificate and key, which must be given together, are presented
+// to servers that require mutual TLS. minVersion applies either way, so a
+// ZVM only offering older protocol versions is refused even when
+// verification is skipped.
+func buildTLSConfig(caCertFile, clientCertFile, clientKeyFile string, insecure bool, minVersion uint16) (*tls.Config, error) {
+	if (clientCertFile == "") != (clientKeyFile == "") {
+		return nil, errors.New("-clientcert and -clientkey must be supplied together")
+	}
+
+	config := &tls.Config{MinVersion: minVersion}
+	if clientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caCertFile == "" {
+		if insecure {
+			slog.Warn("TLS certificate verification is disabled; pass -cacert or -insecure=false to enable it")
+			config.InsecureSkipVerify = true
+		}
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid PEM certificates found in %s", caCertFile)
+	}
+	config.RootCAs = pool
+
+	return config, nil
+}
+
+// parseTLSVersion converts a -tls-min value such as "1.2" to its
+// crypto/tls version constant
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported -tls-min %q: must be 1.2 or 1.3", version)
+	}
+}
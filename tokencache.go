@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultSessionTTL is the default -session-ttl, how long a session token
+// is reused before logging in again. Zerto expires idle sessions after 30
+// minutes.
+const defaultSessionTTL = 30 * time.Minute
+
+// cachedToken is a session persisted by -tokencache
+type cachedToken struct {
+	Token    string    `json:"token"`
+	Bearer   bool      `json:"bearer,omitempty"`
+	IssuedAt time.Time `json:"issuedAt"`
+}
+
+// tokenCache persists session tokens per ZVM in a JSON file so that
+// frequent runs can skip the login. A nil *tokenCache caches nothing.
+type tokenCache struct {
+	path string
+	ttl  time.Duration // -session-ttl
+	mu   sync.Mutex
+}
+
+// tokenKey identifies the ZVM of ep in the token cache by its host, port
+// and API version, so that ZVMs sharing a host never get each other's
+// tokens
+func tokenKey(ep endpoint) string {
+	port := ep.port
+	switch {
+	case port != 0:
+	case ep.apiVersion == apiV2:
+		port = 443
+	default:
+		port = zertoAPIPort
+	}
+	return net.JoinHostPort(ep.host, strconv.Itoa(port)) + "/" + ep.apiVersion
+}
+
+// load returns the cached session for key if it is still within its TTL
+func (c *tokenCache) load(key string) (session, bool) {
+	if c == nil {
+		return session{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.read()[key]
+	if !ok || entry.Token == "" {
+		return session{}, false
+	}
+	sess := session{token: entry.Token, bearer: entry.Bearer, issued: entry.IssuedAt}
+	if sess.expired(c.ttl, time.Now()) {
+		return session{}, false
+	}
+
+	return sess, true
+}
+
+// store records sess for key along with when it was issued
+func (c *tokenCache) store(key string, sess session) {
+	c.update(func(entries map[string]cachedToken) {
+		entries[key] = cachedToken{Token: sess.token, Bearer: sess.bearer, IssuedAt: sess.issued}
+	})
+}
+
+// invalidate forgets any cached session for key
+func (c *tokenCache) invalidate(key string) {
+	c.update(func(entries map[string]cachedToken) {
+		delete(entries, key)
+	})
+}
+
+func (c *tokenCache) update(fn func(map[string]cachedToken)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := c.read()
+	fn(entries)
+	if err := c.write(entries); err != nil {
+		slog.Warn("Failed to update token cache", "path", c.path, "error", err)
+	}
+}
+
+// read returns the cache contents, treating a missing or corrupt file as empty
+func (c *tokenCache) read() map[string]cachedToken {
+	entries := make(map[string]cachedToken)
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Failed to read token cache", "path", c.path, "error", err)
+		}
+		return entries
+	}
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		slog.Warn("Ignoring corrupt token cache", "path", c.path, "error", err)
+		return make(map[string]cachedToken)
+	}
+
+	return entries
+}
+
+// write replaces the cache file atomically. The file is created with 0600
+// permissions since it holds credentials.
+func (c *tokenCache) write(entries map[string]cachedToken) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path, data, 0o600)
+}
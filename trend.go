@@ -0,0 +1,81 @@
+package main
+
+// rpoTrend keeps the average RPO of the last few watch-mode polls to tell
+// whether RPO is improving or degrading. Each connection keeps its own.
+type rpoTrend struct {
+	history []float64 // ring buffer of up to cap(history) averages
+	next    int       // index the next average is stored at once full
+}
+
+// trendPoint is the trend after a poll
+type trendPoint struct {
+	delta float64 // change since the previous poll
+	slope float64 // least-squares slope over the history, per poll
+}
+
+func newRPOTrend(size int) *rpoTrend {
+	return &rpoTrend{history: make([]float64, 0, size)}
+}
+
+// update records avg and returns the trend, or false on the first poll when
+// there is nothing to compare with
+func (t *rpoTrend) update(avg float64) (trendPoint, bool) {
+	var previous float64
+	hasPrevious := len(t.history) > 0
+	if hasPrevious {
+		previous = t.history[(t.next+len(t.history)-1)%len(t.history)]
+	}
+
+	if len(t.history) < cap(t.history) {
+		t.history = append(t.history, avg)
+		t.next = len(t.history) % cap(t.history)
+	} else {
+		t.history[t.next] = avg
+		t.next = (t.next + 1) % len(t.history)
+	}
+
+	if !hasPrevious {
+		return trendPoint{}, false
+	}
+	return trendPoint{delta: avg - previous, slope: t.slope()}, true
+}
+
+// slope fits a least-squares line through the history in poll order and
+// returns its gradient
+func (t *rpoTrend) slope() float64 {
+	n := len(t.history)
+	if n < 2 {
+		return 0
+	}
+
+	// The oldest average is at t.next once the buffer is full; before
+	// that t.next is n, which is index 0 modulo n
+	meanX := float64(n-1) / 2
+	meanY := 0.0
+	for _, y := range t.history {
+		meanY += y
+	}
+	meanY /= float64(n)
+
+	var num, den float64
+	for x := range n {
+		y := t.history[(t.next+x)%n]
+		dx := float64(x) - meanX
+		num += dx * (y - meanY)
+		den += dx * dx
+	}
+	return num / den
+}
+
+// arrow returns ↑ for a rising RPO, ↓ for a falling one and = otherwise,
+// going by the slope as it is steadier than the last delta
+func (p trendPoint) arrow() string {
+	switch {
+	case p.slope > 0:
+		return "↑"
+	case p.slope < 0:
+		return "↓"
+	default:
+		return "="
+	}
+}
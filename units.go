@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unitSeconds maps the -unit values to their length in seconds
+var unitSeconds = map[string]float64{
+	"s": 1,
+	"m": 60,
+	"h": 3600,
+}
+
+// plainFloat formats v with as many digits as it needs but never in
+// scientific notation, which %g switches to from 1e6 and which breaks
+// parsers of the -stats and logfmt lines
+func plainFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// unitHuman is the unit -human selects, spelling durations out in days,
+// hours, minutes and seconds
+const unitHuman = "human"
+
+// formatRPO renders seconds in unit with the unit as a suffix, e.g. "2.5m".
+// Values of at least one unit are rounded to two decimal places; smaller
+// ones keep two significant digits so that 45s shows as "0.75m" rather
+// than a misleading "0m". An empty unit gives the bare number of seconds.
+func formatRPO(seconds int, unit string) string {
+	if unit == "" {
+		return strconv.Itoa(seconds)
+	}
+	if unit == unitHuman {
+		return humanDuration(seconds)
+	}
+
+	value := float64(seconds) / unitSeconds[unit]
+	var text string
+	if math.Abs(value) >= 1 || value == 0 {
+		text = strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", value), "0"), ".")
+	} else {
+		text = strconv.FormatFloat(value, 'g', 2, 64)
+	}
+
+	return text + unit
+}
+
+// formatRPOPrecise renders seconds in unit, or in bare seconds when unit is
+// empty, rounded to the nearest value with precision decimal places
+func formatRPOPrecise(seconds float64, unit string, precision int) string {
+	if unit == "" {
+		return strconv.FormatFloat(seconds, 'f', precision, 64)
+	}
+	return strconv.FormatFloat(seconds/unitSeconds[unit], 'f', precision, 64) + unit
+}
+
+// humanDuration spells seconds out as e.g. "3h 12m 5s", leaving out zero
+// parts and using days from 24 hours. Zero is "0s" and a negative duration,
+// such as a falling trend, keeps its sign: "-1m 30s".
+func humanDuration(seconds int) string {
+	if seconds == 0 {
+		return "0s"
+	}
+	if seconds < 0 {
+		return "-" + humanDuration(-seconds)
+	}
+
+	var parts []string
+	for _, part := range []struct {
+		size   int
+		suffix string
+	}{{86400, "d"}, {3600, "h"}, {60, "m"}, {1, "s"}} {
+		if n := seconds / part.size; n > 0 {
+			parts = append(parts, strconv.Itoa(n)+part.suffix)
+			seconds %= part.size
+		}
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,19 @@
+package main
+
+import "net/http"
+
+// defaultUserAgent identifies the tool and its version in ZVM access logs
+var defaultUserAgent = "zerto-rpo/" + version
+
+// userAgentTransport sets the User-Agent header on every request
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// VRA statuses reported by v1/vras. Only an installed VRA is replicating;
+// every other status means the VPGs routed through it are at risk.
+const (
+	vraInstalled             = 0
+	vraUnsupportedEsxVersion = 1
+	vraNotInstalled          = 2
+	vraInstalling            = 3
+	vraRemoving              = 4
+	vraInstallationError     = 5
+	vraHostPasswordChanged   = 6
+	vraUpdatingIPSettings    = 7
+	vraDuringChangeHost      = 8
+)
+
+var vraStatusNames = map[int]string{
+	vraInstalled:             "Installed",
+	vraUnsupportedEsxVersion: "UnsupportedEsxVersion",
+	vraNotInstalled:          "NotInstalled",
+	vraInstalling:            "Installing",
+	vraRemoving:              "Removing",
+	vraInstallationError:     "InstallationError",
+	vraHostPasswordChanged:   "HostPasswordChanged",
+	vraUpdatingIPSettings:    "UpdatingIpSettings",
+	vraDuringChangeHost:      "DuringChangeHost",
+}
+
+// vraStatusName returns the name of a VRA status, or the number for one
+// this version doesn't know
+func vraStatusName(status int) string {
+	if name, ok := vraStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("Status%d", status)
+}
+
+// vraCounters is how much a VRA protects, or hosts recovery for
+type vraCounters struct {
+	Vpgs int `json:"Vpgs"`
+	Vms  int `json:"Vms"`
+}
+
+// vra is a Virtual Replication Appliance as listed by v1/vras
+type vra struct {
+	VraName           string      `json:"VraName"`
+	VraIdentifier     string      `json:"VraIdentifier"`
+	Status            int         `json:"Status"`
+	ProtectedCounters vraCounters `json:"ProtectedCounters"`
+	RecoveryCounters  vraCounters `json:"RecoveryCounters"`
+}
+
+// vpgCount returns how many VPGs replicate through the VRA, as either the
+// protected or the recovery side
+func (v vra) vpgCount() int {
+	return v.ProtectedCounters.Vpgs + v.RecoveryCounters.Vpgs
+}
+
+// queryVRAs fetches the VRAs managed by the ZVM
+func queryVRAs(ctx context.Context, client *http.Client, ep endpoint, sess session) ([]vra, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", ep.url("v1/vras"), nil)
+	sess.authorize(req, ep)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp.Body)
+
+	if sessionRejected(resp.StatusCode) {
+		return nil, ErrSessionExpired
+	}
+	if err := expectJSON(resp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query VRAs, status code: %d", resp.StatusCode)
+	}
+
+	var vras []vra
+	if err := json.NewDecoder(resp.Body).Decode(&vras); err != nil {
+		return nil, fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+	return vras, nil
+}
+
+// vraAll writes the VRA health of each server to w, for -vra, prefixing
+// each line with the server when there are several. It returns
+// checkCritical if any VRA is unhealthy, or an error if a query failed.
+func vraAll(ctx context.Context, w io.Writer, conns []*connection) error {
+	var failed []string
+	worst := checkOK
+	for _, conn := range conns {
+		var buf bytes.Buffer
+		status, err := vraHealth(ctx, &buf, conn)
+		if len(conns) == 1 {
+			w.Write(buf.Bytes())
+			if err != nil {
+				return err
+			}
+			worst = status
+			break
+		}
+		writePrefixed(w, conn.name()+": ", buf.Bytes())
+		if err != nil {
+			slog.Error("VRA query failed", "server", conn.name(), "error", err)
+			failed = append(failed, conn.name())
+		}
+		worst = max(worst, status)
+	}
+
+	if len(failed) > 0 {
+		return queryError(fmt.Errorf("VRA query failed for %d of %d servers: %s", len(failed), len(conns), strings.Join(failed, ", ")))
+	}
+	if worst != checkOK {
+		return worst
+	}
+	return nil
+}
+
+// vraHealth lists the VRAs of conn that aren't installed and running, with
+// their status and the number of VPGs replicating through them, so that an
+// RPO spike across many VPGs can be traced to the appliance behind it
+func vraHealth(ctx context.Context, w io.Writer, conn *connection) (exitStatus, error) {
+	vras, err := conn.queryVRAs(ctx)
+	if err != nil {
+		return checkOK, err
+	}
+
+	unhealthy := 0
+	for _, v := range vras {
+		if v.Status == vraInstalled {
+			continue
+		}
+		unhealthy++
+		fmt.Fprintf(w, "%s: %s (%d %s)\n", v.VraName, vraStatusName(v.Status), v.vpgCount(), pluralVPGs(v.vpgCount()))
+	}
+
+	if unhealthy > 0 {
+		return checkCritical, nil
+	}
+	fmt.Fprintf(w, "All %d VRAs healthy\n", len(vras))
+	return checkOK, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Supported values for -webhook-format
+const (
+	webhookSlack = "slack"
+	webhookRaw   = "raw"
+)
+
+// webhook posts threshold breaches to -webhook. It has its own client, as
+// the ZVM client's TLS settings and credentials don't apply to it.
+type webhook struct {
+	url    string
+	format string
+	client *http.Client
+}
+
+// webhookPayload is the body posted in raw mode
+type webhookPayload struct {
+	Server   string   `json:"server"`
+	Status   string   `json:"status"`
+	Breaches []breach `json:"breaches"`
+}
+
+// notify posts the breaches of one server's check. Failures are only logged
+// so that a broken webhook never changes the check result.
+func (h *webhook) notify(ctx context.Context, server string, status exitStatus, breaches []breach) {
+	body, err := h.payload(server, status, breaches)
+	if err != nil {
+		slog.Warn("Failed to encode webhook payload", "error", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("Failed to send webhook", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		slog.Warn("Failed to send webhook", "error", err)
+		return
+	}
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode >= 300 {
+		slog.Warn("Webhook rejected the notification", "status", resp.StatusCode)
+		return
+	}
+	slog.Debug("Sent webhook", "server", server, "breaches", len(breaches))
+}
+
+// payload encodes the notification: a Slack message with a text field by
+// default, or the breach details as-is in raw mode
+func (h *webhook) payload(server string, status exitStatus, breaches []breach) ([]byte, error) {
+	if h.format == webhookRaw {
+		return json.Marshal(webhookPayload{Server: server, Status: statusLabel(status), Breaches: breaches})
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s: RPO threshold exceeded on %s", statusLabel(status), server)
+	for _, b := range breaches {
+		fmt.Fprintf(&text, "\n• %s: %ds (threshold %ds)", b.VPG, b.RPO, b.Threshold)
+	}
+	return json.Marshal(map[string]string{"text": text.String()})
+}
+
+// statusLabel names a Nagios status as in the check output
+func statusLabel(status exitStatus) string {
+	switch status {
+	case checkOK:
+		return "OK"
+	case checkWarning:
+		return "WARNING"
+	default:
+		return "CRITICAL"
+	}
+}
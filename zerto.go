@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	zertoAPIPort = 9669
+
+	// maxVPGPages bounds pagination in case the server misreports its total
+	maxVPGPages = 1000
+
+	// errorSnippetLen is how much of an unexpected response body is quoted
+	errorSnippetLen = 200
+
+	// keycloakClientID is the public client the ZVMA uses for API logins
+	keycloakClientID = "zerto-client"
+
+	// defaultNameField is the JSON field the API puts VPG names in
+	defaultNameField = "VpgName"
+
+	// defaultSessionHeader carries v1 session tokens unless -authheader
+	// names another header
+	defaultSessionHeader = "X-Zerto-Session"
+)
+
+// Supported values for the -apiversion flag
+const (
+	apiV1 = "v1" // legacy Windows ZVM on port 9669 with X-Zerto-Session tokens
+	apiV2 = "v2" // Linux ZVM appliance on port 443 with keycloak bearer tokens
+)
+
+// ErrSessionExpired is returned when Zerto rejects the session token with a
+// 401 or 403, typically because it expired; logging in again fixes it
+var ErrSessionExpired = errors.New("session token rejected by Zerto API")
+
+// sessionRejected reports whether status means the session token is no
+// longer accepted
+func sessionRejected(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// endpoint describes how to reach the Zerto API on a single ZVM
+type endpoint struct {
+	host       string
+	port       int // 0 for the API version's default port
+	apiVersion string
+	authHeader string // header carrying v1 session tokens
+	basePath   string // prefix for every API path, without surrounding slashes
+}
+
+// url returns the absolute URL of an API path such as "v1/vpgs"
+func (e endpoint) url(path string) string {
+	if e.basePath != "" {
+		path = e.basePath + "/" + path
+	}
+
+	switch {
+	case e.port != 0:
+		return fmt.Sprintf("https://%s/%s", net.JoinHostPort(e.host, strconv.Itoa(e.port)), path)
+	case e.apiVersion == apiV2:
+		return fmt.Sprintf("https://%s/%s", bracketHost(e.host), path)
+	default:
+		return fmt.Sprintf("https://%s/%s", net.JoinHostPort(e.host, strconv.Itoa(zertoAPIPort)), path)
+	}
+}
+
+// bracketHost wraps an IPv6 literal in brackets so it can stand alone as
+// the host of a URL. Hostnames and IPv4 addresses are returned unchanged.
+func bracketHost(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// session is the credential sent with every API request after logging in
+type session struct {
+	token  string
+	bearer bool      // token is an OAuth access token rather than a session token
+	issued time.Time // when the ZVM issued the token; zero for a -token
+}
+
+// expired reports whether the session is older than ttl and should be
+// replaced by logging in again. A ttl of 0, or a session with no issue time,
+// never expires; it is only replaced once the ZVM rejects it.
+func (s session) expired(ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && !s.issued.IsZero() && now.Sub(s.issued) >= ttl
+}
+
+// authorize adds the session credential to req, sending a session token in
+// the endpoint's session header
+func (s session) authorize(req *http.Request, ep endpoint) {
+	if s.bearer {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else {
+		req.Header.Set(ep.authHeader, s.token)
+	}
+}
+
+// login authenticates with OAuth client credentials when the config has them
+// and with username/password otherwise. A -token is used as is, without
+// contacting the ZVM. Failures to reach the ZVM are described in plain terms.
+func login(ctx context.Context, client *http.Client, ep endpoint, config *Config) (session, error) {
+	if config.Token != "" {
+		return session{token: config.Token, bearer: true}, nil
+	}
+	var sess session
+	var err error
+	if config.ClientID != "" && config.ClientSecret != "" {
+		sess, err = loginToZertoOAuth(ctx, client, ep, config.ClientID, config.ClientSecret)
+	} else {
+		sess, err = loginToZerto(ctx, client, ep, config.Username, config.Password)
+	}
+	if err != nil {
+		return session{}, describeNetError(ep, err)
+	}
+	sess.issued = time.Now()
+	return sess, nil
+}
+
+// loginToZerto logs in with username and password. Both are sent byte for
+// byte, so passwords may contain ':', '@', non-ASCII characters or trailing
+// spaces. Basic auth splits on the first ':', so v1 cannot accept a colon in
+// the username; the v2 token request form-encodes both and has no such limit.
+func loginToZerto(ctx context.Context, client *http.Client, ep endpoint, username, password string) (session, error) {
+	if ep.apiVersion == apiV2 {
+		return requestToken(ctx, client, ep, url.Values{
+			"grant_type": {"password"},
+			"client_id":  {keycloakClientID},
+			"username":   {username},
+			"password":   {password},
+		})
+	}
+
+	if strings.Contains(username, ":") {
+		return session{}, errors.New("username must not contain ':' with the v1 API, which uses basic auth")
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", ep.url("v1/session/add"), nil)
+	req.SetBasicAuth(username, password)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return session{}, err
+	}
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		if err := expectJSON(resp); err != nil {
+			return session{}, fmt.Errorf("failed to login: %w", err)
+		}
+		return session{}, fmt.Errorf("failed to login, status code: %d", resp.StatusCode)
+	}
+
+	sessionToken := resp.Header.Get(ep.authHeader)
+	if sessionToken == "" {
+		sessionToken = sessionTokenFromBody(resp.Body)
+	}
+	if sessionToken == "" {
+		return session{}, fmt.Errorf("session token not found in %s header or response body", ep.authHeader)
+	}
+
+	return session{token: sessionToken}, nil
+}
+
+// sessionTokenFromBody reads the session token from a login response body,
+// which some ZVMs send instead of the header, either as a bare JSON string
+// or in a Token or SessionId field. It returns "" if there is none.
+func sessionTokenFromBody(body io.Reader) string {
+	data, err := io.ReadAll(io.LimitReader(body, 64<<10))
+	if err != nil {
+		return ""
+	}
+
+	var token string
+	if json.Unmarshal(data, &token) == nil {
+		return token
+	}
+	var fields struct {
+		Token     string `json:"Token"`
+		SessionID string `json:"SessionId"`
+	}
+	if json.Unmarshal(data, &fields) != nil {
+		return ""
+	}
+	if fields.Token != "" {
+		return fields.Token
+	}
+	return fields.SessionID
+}
+
+// loginToZertoOAuth obtains a bearer token from keycloak using the OAuth
+// client credentials grant
+func loginToZertoOAuth(ctx context.Context, client *http.Client, ep endpoint, clientID, clientSecret string) (session, error) {
+	return requestToken(ctx, client, ep, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	})
+}
+
+// requestToken exchanges form for an access token at the ZVMA's keycloak
+// token endpoint
+func requestToken(ctx context.Context, client *http.Client, ep endpoint, form url.Values) (session, error) {
+	req, _ := http.NewRequestWithContext(ctx, "POST", ep.url("auth/realms/zerto/protocol/openid-connect/token"), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return session{}, err
+	}
+	defer closeBody(resp.Body)
+
+	if err := expectJSON(resp); err != nil {
+		return session{}, fmt.Errorf("failed to obtain access token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return session{}, fmt.Errorf("failed to obtain access token, status code: %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return session{}, fmt.Errorf("error decoding token response: %v", err)
+	}
+	if token.AccessToken == "" {
+		return session{}, errors.New("access token not found in response")
+	}
+
+	return session{token: token.AccessToken, bearer: true}, nil
+}
+
+// logoutFromZerto deletes the session so that stale sessions don't pile up
+// on the ZVM. Failures are only logged because the RPO data has already been
+// retrieved by the time this runs. Bearer tokens simply expire, so there is
+// nothing to delete for them.
+func logoutFromZerto(ctx context.Context, client *http.Client, ep endpoint, sess session) {
+	if sess.bearer {
+		return
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", ep.url("v1/session"), nil)
+	sess.authorize(req, ep)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("Failed to log out of Zerto API", "server", ep.host, "error", err)
+		return
+	}
+	defer closeBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("Failed to log out of Zerto API", "server", ep.host, "status", resp.StatusCode)
+	}
+}
+
+// vpgQuery holds the parameters of a VPG list request
+type vpgQuery struct {
+	pageSize  int    // 0 leaves the page size up to the server
+	name      string // only the VPG with this name, if set
+	nameField string // JSON field holding the VPG name, VpgName if empty
+}
+
+// eachVPGPage fetches every VPG, following pagination when the ZVM reports
+// a total count larger than the first page or when a page size is
+// requested. Each page is handed to fn as it arrives so that callers can
+// stream them; an error from fn stops the paging and is returned.
+func eachVPGPage(ctx context.Context, client *http.Client, ep endpoint, sess session, q vpgQuery, fn func([]VPG) error) error {
+	seen := 0
+	for page := 1; ; page++ {
+		batch, total, err := queryVPGPage(ctx, client, ep, sess, page, q)
+		if err != nil {
+			return err
+		}
+		seen += len(batch)
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case len(batch) == 0:
+			return nil
+		case total >= 0:
+			if seen >= total {
+				return nil
+			}
+		case q.pageSize == 0 || len(batch) != q.pageSize:
+			// A short page is the last one, and a long one means the
+			// server ignored the paging parameters altogether
+			return nil
+		}
+
+		// Don't trust a server that keeps claiming there is more to come
+		if page >= maxVPGPages {
+			return fmt.Errorf("gave up after %d pages with %d VPGs retrieved", page, seen)
+		}
+	}
+}
+
+// queryVPGPage fetches and decodes one page of VPGs. total is the
+// X-Total-Count header value, or -1 if absent.
+func queryVPGPage(ctx context.Context, client *http.Client, ep endpoint, sess session, page int, q vpgQuery) (vpgs []VPG, total int, err error) {
+	body, total, err := fetchVPGPage(ctx, client, ep, sess, page, q)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if vpgs, err = decodeVPGs(body, q.nameField); err != nil {
+		return nil, 0, fmt.Errorf("error unmarshalling JSON: %v", err)
+	}
+
+	return vpgs, total, nil
+}
+
+// fetchVPGPage returns the raw JSON of one page of VPGs. When no page size
+// is set the first page is requested without paging parameters, exactly as
+// a non-paginating ZVM expects. A name is passed as the API's vpgName
+// filter, which older ZVMs may ignore.
+func fetchVPGPage(ctx context.Context, client *http.Client, ep endpoint, sess session, page int, q vpgQuery) (body []byte, total int, err error) {
+	query := url.Values{}
+	if page > 1 || q.pageSize > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if q.pageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(q.pageSize))
+	}
+	if q.name != "" {
+		query.Set("vpgName", q.name)
+	}
+
+	apiURL := ep.url("v1/vpgs")
+	if len(query) > 0 {
+		apiURL += "?" + query.Encode()
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	sess.authorize(req, ep)
+	// Asking for gzip explicitly turns off the transport's transparent
+	// decompression, so gunzipBody takes care of it
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closeBody(resp.Body)
+
+	if err := gunzipBody(resp); err != nil {
+		return nil, 0, err
+	}
+
+	if sessionRejected(resp.StatusCode) {
+		return nil, 0, ErrSessionExpired
+	}
+	if err := expectJSON(resp); err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to query VPGs, status code: %d", resp.StatusCode)
+	}
+
+	total = -1
+	if header := resp.Header.Get("X-Total-Count"); header != "" {
+		if total, err = strconv.Atoi(header); err != nil {
+			return nil, 0, fmt.Errorf("invalid X-Total-Count header %q", header)
+		}
+	}
+
+	if body, err = io.ReadAll(resp.Body); err != nil {
+		return nil, 0, err
+	}
+	return body, total, nil
+}
+
+// vpgListJSON returns the JSON array of VPGs in body. Most ZVMs return a
+// bare array, but some versions wrap it in an object as {"Vpgs": [...]}.
+func vpgListJSON(body []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return body, nil
+	}
+
+	var envelope struct {
+		Vpgs json.RawMessage `json:"Vpgs"`
+	}
+	if err := json.Unmarshal(trimmed, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Vpgs == nil {
+		return nil, errors.New("response is an object without a Vpgs list")
+	}
+	return envelope.Vpgs, nil
+}
+
+// decodeVPGs parses a VPG list, bare or wrapped in an envelope. With a
+// nameField other than VpgName the list is also decoded generically to take
+// each name from that field; otherwise only the typed decode is done, as it
+// is faster.
+func decodeVPGs(body []byte, nameField string) ([]VPG, error) {
+	body, err := vpgListJSON(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var vpgs []VPG
+	if err := json.Unmarshal(body, &vpgs); err != nil {
+		return nil, err
+	}
+	if nameField == "" || nameField == defaultNameField {
+		return vpgs, nil
+	}
+
+	var raw []map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	for i := range vpgs {
+		name, ok := raw[i][nameField].(string)
+		if !ok {
+			return nil, fmt.Errorf("VPG %d has no string field %q", i, nameField)
+		}
+		vpgs[i].VpgName = name
+	}
+	return vpgs, nil
+}
+
+// gunzipBody replaces the body of a gzip-encoded response with a reader of
+// the decompressed content. Responses from servers that ignored the
+// Accept-Encoding header are left as they are.
+func gunzipBody(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error decompressing response: %w", err)
+	}
+	resp.Body = &gzipBody{Reader: zr, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	return nil
+}
+
+// gzipBody closes both the gzip reader and the underlying response body
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// expectJSON returns a descriptive error when resp declares a non-JSON
+// content type, quoting the start of the body. That is typically an HTML
+// error page from a rebooting ZVM or a proxy, which would otherwise surface
+// as a cryptic unmarshalling error. A missing Content-Type is given the
+// benefit of the doubt.
+func expectJSON(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, errorSnippetLen))
+	return fmt.Errorf("expected JSON but got %s (status %d): %q", contentType, resp.StatusCode, snippet)
+}
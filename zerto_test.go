@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	mockUsername = "admin"
+	mockPassword = "secret"
+	mockToken    = "tok123"
+)
+
+// mockZVM is a fake v1 ZVM. /v1/session/add accepts the username and
+// password set on it and hands out mockToken in the X-Zerto-Session header,
+// or returns loginBody instead, and /v1/vpgs serves vpgs to that session.
+type mockZVM struct {
+	*httptest.Server
+
+	username, password string
+	vpgs               string // body of /v1/vpgs
+	loginBody          string // if set, sent as the login response body instead of the header
+
+	logins  atomic.Int32 // successful logins
+	queries atomic.Int32 // /v1/vpgs requests, accepted or not
+}
+
+// newMockZVM starts a mockZVM serving vpgs over TLS, stopped when the test
+// ends
+func newMockZVM(t *testing.T, vpgs string) *mockZVM {
+	t.Helper()
+	zvm := &mockZVM{username: mockUsername, password: mockPassword, vpgs: vpgs}
+	zvm.Server = httptest.NewTLSServer(http.HandlerFunc(zvm.serveHTTP))
+	t.Cleanup(zvm.Close)
+	return zvm
+}
+
+func (z *mockZVM) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/session/add":
+		username, password, ok := r.BasicAuth()
+		if !ok || username != z.username || password != z.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		z.logins.Add(1)
+		if z.loginBody != "" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, z.loginBody)
+			return
+		}
+		w.Header().Set(defaultSessionHeader, mockToken)
+	case "/v1/session":
+	case "/v1/vpgs":
+		z.queries.Add(1)
+		if r.Header.Get(defaultSessionHeader) != mockToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, z.vpgs)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// endpoint returns the endpoint of the mock ZVM
+func (z *mockZVM) endpoint(t *testing.T) endpoint {
+	t.Helper()
+	host, portText, err := net.SplitHostPort(z.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return endpoint{host: host, port: port, apiVersion: apiV1, authHeader: defaultSessionHeader}
+}
+
+// connection returns a connection to the mock ZVM with its credentials
+func (z *mockZVM) connection(t *testing.T) *connection {
+	return &connection{
+		client: z.Client(),
+		ep:     z.endpoint(t),
+		config: &Config{Username: z.username, Password: z.password},
+	}
+}
+
+func TestLoginAndQueryVPGs(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		vpgs     string
+		want     []string // VPG names
+		loginErr bool
+		queryErr bool
+	}{
+		{
+			name:     "two VPGs",
+			password: mockPassword,
+			vpgs:     `[{"VpgName":"web","ActualRPO":10},{"VpgName":"db","ActualRPO":25}]`,
+			want:     []string{"web", "db"},
+		},
+		{
+			name:     "auth failure",
+			password: "wrong",
+			vpgs:     `[]`,
+			loginErr: true,
+		},
+		{
+			name:     "empty VPG list",
+			password: mockPassword,
+			vpgs:     `[]`,
+		},
+		{
+			name:     "malformed JSON",
+			password: mockPassword,
+			vpgs:     `[{"VpgName":"web","ActualRPO":`,
+			queryErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zvm := newMockZVM(t, tt.vpgs)
+			ctx := context.Background()
+
+			sess, err := loginToZerto(ctx, zvm.Client(), zvm.endpoint(t), mockUsername, tt.password)
+			if tt.loginErr {
+				if err == nil {
+					t.Fatal("loginToZerto succeeded with a wrong password")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loginToZerto: %v", err)
+			}
+			if sess.token != mockToken {
+				t.Fatalf("session token = %q, want %q", sess.token, mockToken)
+			}
+
+			conn := zvm.connection(t)
+			conn.sess = sess
+			vpgs, err := conn.queryVPGs(ctx, vpgQuery{})
+			if tt.queryErr {
+				if err == nil {
+					t.Fatal("queryVPGs succeeded on malformed JSON")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("queryVPGs: %v", err)
+			}
+
+			var names []string
+			for _, vpg := range vpgs {
+				names = append(names, vpg.VpgName)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Errorf("VPGs = %v, want %v", names, tt.want)
+			}
+			if n := zvm.logins.Load(); n != 1 {
+				t.Errorf("%d logins, want 1", n)
+			}
+		})
+	}
+}
+
+func TestLoginSpecialCharacterPasswords(t *testing.T) {
+	passwords := []string{
+		"pass:word",
+		"p@ss",
+		"pässwörd✓",
+		"trailing space ",
+		" :@ mixed\t",
+	}
+
+	for _, password := range passwords {
+		t.Run(strconv.Quote(password), func(t *testing.T) {
+			zvm := newMockZVM(t, `[]`)
+			zvm.password = password
+
+			if _, err := loginToZerto(context.Background(), zvm.Client(), zvm.endpoint(t), mockUsername, password); err != nil {
+				t.Fatalf("loginToZerto: %v", err)
+			}
+		})
+	}
+}
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		server     string
+		apiVersion string
+		want       string
+	}{
+		{"10.0.0.1", apiV1, "https://10.0.0.1:9669/v1/vpgs"},
+		{"zvm.example.com", apiV1, "https://zvm.example.com:9669/v1/vpgs"},
+		{"::1", apiV1, "https://[::1]:9669/v1/vpgs"},
+		{"[::1]", apiV1, "https://[::1]:9669/v1/vpgs"},
+		{"[2001:db8::1]:9443", apiV1, "https://[2001:db8::1]:9443/v1/vpgs"},
+		{"10.0.0.1", apiV2, "https://10.0.0.1/v1/vpgs"},
+		{"2001:db8::1", apiV2, "https://[2001:db8::1]/v1/vpgs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server+" "+tt.apiVersion, func(t *testing.T) {
+			addr, err := parseServerAddr(tt.server)
+			if err != nil {
+				t.Fatalf("parseServerAddr: %v", err)
+			}
+			ep := endpoint{host: addr.host, port: addr.port, apiVersion: tt.apiVersion}
+			if got := ep.url("v1/vpgs"); got != tt.want {
+				t.Errorf("url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeVPGsBareAndEnvelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		nameField string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "bare", body: `[{"VpgName":"web"},{"VpgName":"db"}]`, want: []string{"web", "db"}},
+		{name: "envelope", body: `{"Vpgs":[{"VpgName":"web"},{"VpgName":"db"}]}`, want: []string{"web", "db"}},
+		{name: "envelope with whitespace", body: "\n  {\"Vpgs\": [{\"VpgName\":\"web\"}]}\n", want: []string{"web"}},
+		{name: "empty envelope", body: `{"Vpgs":[]}`},
+		{name: "envelope with name field", body: `{"Vpgs":[{"Name":"web"}]}`, nameField: "Name", want: []string{"web"}},
+		{name: "object without Vpgs", body: `{"Message":"error"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpgs, err := decodeVPGs([]byte(tt.body), tt.nameField)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeVPGs succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeVPGs: %v", err)
+			}
+
+			var names []string
+			for _, vpg := range vpgs {
+				names = append(names, vpg.VpgName)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Errorf("VPGs = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginTokenDelivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		loginBody string
+		wantErr   bool
+	}{
+		{name: "header"},
+		{name: "body SessionId", loginBody: `{"SessionId":"` + mockToken + `"}`},
+		{name: "body Token", loginBody: `{"Token":"` + mockToken + `"}`},
+		{name: "body string", loginBody: `"` + mockToken + `"`},
+		{name: "neither", loginBody: `{}`, wantErr: true},
+		{name: "body not JSON", loginBody: `OK`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zvm := newMockZVM(t, `[]`)
+			zvm.loginBody = tt.loginBody
+
+			sess, err := loginToZerto(context.Background(), zvm.Client(), zvm.endpoint(t), mockUsername, mockPassword)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("loginToZerto succeeded with token %q, want an error", sess.token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loginToZerto: %v", err)
+			}
+			if sess.token != mockToken {
+				t.Errorf("session token = %q, want %q", sess.token, mockToken)
+			}
+		})
+	}
+}